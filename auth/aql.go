@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/lorenyeung/forceReindexXray/helpers"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var aqlHeader = map[string]string{
+	"Content-Type": "text/plain",
+}
+
+//aqlItem is a file found by an AQL items search
+type aqlItem struct {
+	Repo     string `json:"repo"`
+	Path     string `json:"path"`
+	Name     string `json:"name"`
+	Size     int64  `json:"size"`
+	Sha256   string `json:"sha256"`
+	Modified string `json:"modified"`
+	Created  string `json:"created"`
+}
+
+//aqlTimeLayout is how AQL compares dates
+const aqlTimeLayout = "2006-01-02T15:04:05.000Z"
+
+func (c artifactoryClient) SearchFiles(ctx context.Context, repo, folder string, extensions []string, window helpers.TimeWindow, pageSize int, found func(helpers.Files) error) error {
+	if len(extensions) == 0 {
+		return nil
+	}
+	criteria, err := json.Marshal(aqlCriteria(repo, folder, extensions, window))
+	if err != nil {
+		return newRequestError(ErrRequest, "POST", c.creds.ArtifactoryAPI("/api/search/aql"), 0, err)
+	}
+	//the prefix stripped from each file so uris are relative to folder, as in the storage list
+	prefix := strings.TrimSuffix(folder, "/")
+	for offset := 0; ; offset += pageSize {
+		query := fmt.Sprintf(`items.find(%s).include("repo","path","name","size","sha256","modified","created").sort({"$asc":["path","name"]}).offset(%d).limit(%d)`, criteria, offset, pageSize)
+		url := c.creds.ArtifactoryAPI("/api/search/aql")
+		var count int
+		_, err := StreamRestAPIContext(ctx, ServiceArtifactory, "POST", true, url, c.creds.Username, c.creds.Apikey, query, aqlHeader, 0, func(body io.Reader) error {
+			return streamArray(body, "results", func(decoder *json.Decoder) error {
+				var item aqlItem
+				if err := decoder.Decode(&item); err != nil {
+					return err
+				}
+				count++
+				uri := "/" + item.Name
+				if item.Path != "." {
+					uri = "/" + item.Path + uri
+				}
+				return found(helpers.Files{
+					Uri:          strings.TrimPrefix(uri, prefix),
+					Size:         item.Size,
+					LastModified: item.Modified,
+					Sha256:       item.Sha256,
+					Created:      item.Created,
+				})
+			})
+		})
+		if err != nil {
+			return err
+		}
+		log.Debug("AQL page at offset ", offset, " of ", repo, " returned ", count, " files")
+		if count < pageSize {
+			return nil
+		}
+	}
+}
+
+//aqlCriteria finds the files of repo under folder whose path or name contains one of extensions, inside window.
+//The storage list is filtered on the whole uri, so both backends select the same files
+func aqlCriteria(repo, folder string, extensions []string, window helpers.TimeWindow) map[string]interface{} {
+	var names []interface{}
+	for _, extension := range extensions {
+		names = append(names,
+			map[string]interface{}{"name": map[string]string{"$match": "*" + extension + "*"}},
+			map[string]interface{}{"path": map[string]string{"$match": "*" + extension + "*"}})
+	}
+	and := []interface{}{map[string]interface{}{"$or": names}}
+	if folder = strings.Trim(folder, "/"); folder != "" {
+		and = append(and, map[string]interface{}{"$or": []interface{}{
+			map[string]interface{}{"path": folder},
+			map[string]interface{}{"path": map[string]string{"$match": folder + "/*"}},
+		}})
+	}
+	bound := func(field, operator string, t time.Time) {
+		if !t.IsZero() {
+			and = append(and, map[string]interface{}{field: map[string]string{operator: t.UTC().Format(aqlTimeLayout)}})
+		}
+	}
+	bound("created", "$gte", window.CreatedAfter)
+	bound("created", "$lt", window.CreatedBefore)
+	bound("modified", "$gte", window.ModifiedAfter)
+	bound("modified", "$lt", window.ModifiedBefore)
+	return map[string]interface{}{"repo": repo, "type": "file", "$and": and}
+}
@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+
+	"github.com/lorenyeung/forceReindexXray/helpers"
+)
+
+//ArtifactoryClient is the part of the Artifactory API the reindex logic uses
+type ArtifactoryClient interface {
+	//Ping checks the URL and credentials
+	Ping(ctx context.Context) error
+	//IndexedRepos lists the repositories set for Xray indexing
+	IndexedRepos(ctx context.Context) ([]IndexedRepo, error)
+	//ListFiles passes every file under folder of repo to found as the list arrives, with uris relative to folder.
+	//Listing stops at the first error found returns
+	ListFiles(ctx context.Context, repo, folder string, found func(helpers.Files) error) error
+	//SearchFiles finds the files under folder of repo whose path or name contains one of extensions and that fall inside
+	//window with AQL, fetching pageSize files per request. Files are passed to found as with ListFiles
+	SearchFiles(ctx context.Context, repo, folder string, extensions []string, window helpers.TimeWindow, pageSize int, found func(helpers.Files) error) error
+	//FileInfo returns the storage info of a file or folder
+	FileInfo(ctx context.Context, repo, path string) (helpers.FileInfo, error)
+}
+
+type artifactoryClient struct {
+	creds Creds
+}
+
+//NewArtifactoryClient returns an ArtifactoryClient for creds.ArtifactoryURL
+func NewArtifactoryClient(creds Creds) ArtifactoryClient {
+	return artifactoryClient{creds: creds}
+}
+
+func (c artifactoryClient) get(ctx context.Context, path string, retry int) ([]byte, string, error) {
+	url := c.creds.ArtifactoryAPI(path)
+	data, _, _, err := GetRestAPIContext(ctx, ServiceArtifactory, "GET", true, url, c.creds.Username, c.creds.Apikey, "", nil, retry)
+	return data, url, err
+}
+
+func (c artifactoryClient) Ping(ctx context.Context) error {
+	data, url, err := c.get(ctx, "/api/system/ping", 0)
+	if err != nil {
+		return err
+	}
+	if string(data) != "OK" {
+		return newRequestError(ErrAuth, "GET", url, 0, errors.New("unexpected response: "+string(data)))
+	}
+	return nil
+}
+
+func (c artifactoryClient) IndexedRepos(ctx context.Context) ([]IndexedRepo, error) {
+	data, url, err := c.get(ctx, "/api/xrayRepo/getIndex", 0)
+	if err != nil {
+		return nil, err
+	}
+	var result []IndexedRepo
+	return result, DecodeJSON(data, &result, url)
+}
+
+func (c artifactoryClient) ListFiles(ctx context.Context, repo, folder string, found func(helpers.Files) error) error {
+	url := c.creds.ArtifactoryAPI("/api/storage/" + repo + folder + "?list&deep=1")
+	//the list of a large repo does not fit in memory, files are decoded one at a time
+	_, err := StreamRestAPIContext(ctx, ServiceArtifactory, "GET", true, url, c.creds.Username, c.creds.Apikey, "", nil, 0, func(body io.Reader) error {
+		return streamArray(body, "files", func(decoder *json.Decoder) error {
+			var file helpers.Files
+			if err := decoder.Decode(&file); err != nil {
+				return err
+			}
+			return found(file)
+		})
+	})
+	return err
+}
+
+func (c artifactoryClient) FileInfo(ctx context.Context, repo, path string) (helpers.FileInfo, error) {
+	var fileInfo helpers.FileInfo
+	data, url, err := c.get(ctx, "/api/storage/"+repo+path, 0)
+	if err != nil {
+		return fileInfo, err
+	}
+	return fileInfo, DecodeJSON(data, &fileInfo, url)
+}
@@ -2,7 +2,7 @@ package auth
 
 import (
 	"bytes"
-	"encoding/json"
+	"context"
 	"io"
 	"io/ioutil"
 	"mime/multipart"
@@ -17,11 +17,14 @@ import (
 )
 
 //Creds struct for creating download.json
+//When Username is empty, Apikey holds an access token and is sent as a Bearer token
 type Creds struct {
-	URL        string
-	Username   string
-	Apikey     string
-	DlLocation string
+	URL            string
+	ArtifactoryURL string
+	XrayURL        string
+	Username       string
+	Apikey         string
+	DlLocation     string
 }
 
 type IndexedRepo struct {
@@ -30,26 +33,66 @@ type IndexedRepo struct {
 	Type    string `json:"type"`
 }
 
-// VerifyAPIKey for errors
-func VerifyAPIKey(urlInput, userName, apiKey string) bool {
-	log.Debug("starting VerifyAPIkey request. Testing:", userName)
-	//TODO need to sanitize invalid url strings, esp in custom flag
-	data, _, _ := GetRestAPI("GET", true, urlInput+"/artifactory/api/system/ping", userName, apiKey, "", nil, 1)
-	if string(data) == "OK" {
-		log.Debug("finished VerifyAPIkey request. Credentials are good to go.")
-		return true
-	}
-	log.Warn("Received unexpected response:", string(data), " against ", urlInput+"/artifactory/api/system/ping. Double check your URL and credentials.")
-	return false
+//GetRestAPIContext sends a request bound to ctx with error handling, returning a *RequestError on failure.
+//No new attempt is started once ctx is cancelled, while an attempt already in flight is given the shutdown grace period to finish.
+//retry is the number of attempts already made, or NoRetry for a single attempt
+func GetRestAPIContext(ctx context.Context, service Service, method string, auth bool, urlInput, userName, apiKey, providedfilepath string, header map[string]string, retry int) ([]byte, int, http.Header, error) {
+	return restAPI(ctx, service, method, auth, urlInput, userName, apiKey, providedfilepath, header, retry, nil)
 }
 
-//GetRestAPI GET rest APIs response with error handling
-func GetRestAPI(method string, auth bool, urlInput, userName, apiKey, providedfilepath string, header map[string]string, retry int) ([]byte, int, http.Header) {
-	if retry > 5 {
-		log.Warn("Exceeded retry limit, cancelling further attempts")
-		return nil, 0, nil
+//StreamRestAPIContext is GetRestAPIContext for large responses, the body of a successful response is passed to read
+//as it arrives instead of being held in memory. Failed attempts are only retried before read is called, what read
+//already consumed cannot be taken back
+func StreamRestAPIContext(ctx context.Context, service Service, method string, auth bool, urlInput, userName, apiKey, providedfilepath string, header map[string]string, retry int, read func(io.Reader) error) (int, error) {
+	_, statusCode, _, err := restAPI(ctx, service, method, auth, urlInput, userName, apiKey, providedfilepath, header, retry, read)
+	return statusCode, err
+}
+
+//restAPI makes the attempts of a request, handing successful bodies to read when it is set
+func restAPI(ctx context.Context, service Service, method string, auth bool, urlInput, userName, apiKey, providedfilepath string, header map[string]string, retry int, read func(io.Reader) error) ([]byte, int, http.Header, error) {
+	payload := requestBody(method, providedfilepath)
+	var retryAfter time.Duration
+	var lastErr *RequestError
+	first, maxAttempts := retry, retryPolicy.MaxAttempts
+	if retry == NoRetry {
+		first, maxAttempts = 0, 1
+	}
+	attempt := first
+	for ; attempt < maxAttempts; attempt++ {
+		if attempt > first {
+			wait := retryPolicy.backoff(attempt-first, retryAfter)
+			log.Warn("Sleeping ", wait, " then retrying ", method, " request for ", urlInput, ", attempt ", attempt)
+			if !sleepContext(ctx, wait) {
+				log.Warn("Cancelled, not retrying ", method, " request for ", urlInput)
+				return nil, 0, nil, newRequestError(ErrCancelled, method, urlInput, 0, ctx.Err())
+			}
+		}
+		if !limiterFor(service).wait(ctx) || ctx.Err() != nil {
+			log.Debug("Cancelled, not sending ", method, " request for ", urlInput)
+			return nil, 0, nil, newRequestError(ErrCancelled, method, urlInput, 0, ctx.Err())
+		}
+		reqCtx, cancel := inFlight(ctx)
+		data, statusCode, headers, retryable, err := doRequest(reqCtx, method, auth, urlInput, userName, apiKey, providedfilepath, header, payload, attempt, read)
+		cancel()
+		if !retryable {
+			if err != nil {
+				return data, statusCode, headers, err
+			}
+			return data, statusCode, headers, nil
+		}
+		lastErr = err
+		retryAfter = parseRetryAfter(headers)
 	}
+	log.Warn("Exceeded retry limit, cancelling further attempts")
+	if lastErr == nil {
+		lastErr = newRequestError(ErrNetwork, method, urlInput, 0, nil)
+	}
+	lastErr.Attempts = attempt - first
+	return nil, lastErr.StatusCode, nil, lastErr
+}
 
+//requestBody builds the payload once so it can be resent on every attempt
+func requestBody(method, providedfilepath string) []byte {
 	body := new(bytes.Buffer)
 	if method == "POST" && providedfilepath != "" {
 		body = bytes.NewBuffer([]byte(providedfilepath))
@@ -69,96 +112,131 @@ func GetRestAPI(method string, auth bool, urlInput, userName, apiKey, providedfi
 		err = writer.Close()
 		helpers.Check(err, false, "writer close", helpers.Trace())
 	}
+	return body.Bytes()
+}
 
-	client := http.Client{}
-	req, err := http.NewRequest(method, urlInput, body)
+//doRequest performs a single attempt, the retryable return value reports whether it should be retried
+func doRequest(ctx context.Context, method string, auth bool, urlInput, userName, apiKey, providedfilepath string, header map[string]string, payload []byte, attempt int, read func(io.Reader) error) (data []byte, statusCode int, headers http.Header, retryable bool, reqErr *RequestError) {
+	client, sendCtx := httpClient, ctx
+	var stopRead context.CancelFunc
+	if read != nil {
+		//streamed bodies are read as fast as read consumes them, which the overall timeout cannot allow for. Each
+		//read of the body is given the read timeout instead
+		streaming := *httpClient
+		streaming.Timeout = 0
+		client = &streaming
+		sendCtx, stopRead = context.WithCancel(ctx)
+		defer stopRead()
+	}
+	req, err := http.NewRequestWithContext(sendCtx, method, urlInput, bytes.NewReader(payload))
+	if err != nil {
+		log.Warn("The HTTP request failed with error", err)
+		return nil, 0, nil, false, newRequestError(ErrRequest, method, urlInput, 0, err)
+	}
 	if auth {
-		req.SetBasicAuth(userName, apiKey)
+		setAuth(req, userName, apiKey)
 	}
 	for x, y := range header {
-		log.Debug("Recieved extra header:", x+":"+y)
+		log.Debug("Recieved extra header:", x+":"+helpers.RedactHeader(x, y))
 		req.Header.Set(x, y)
 	}
 
+	resp, err := client.Do(req)
+	helpers.Check(err, false, "The HTTP response", helpers.Trace())
 	if err != nil {
-		log.Warn("The HTTP request failed with error", err)
-	} else {
-
-		resp, err := client.Do(req)
-		helpers.Check(err, false, "The HTTP response", helpers.Trace())
+		if isTimeout(err) {
+			log.Error("Timed out on ", method, " request for ", urlInput, ", attempt ", attempt)
+		}
+		category := ErrNetwork
+		if ctx.Err() != nil {
+			category = ErrCancelled
+		}
+		return nil, 0, nil, category == ErrNetwork && isRetryableError(err), newRequestError(category, method, urlInput, 0, err)
+	}
+	defer resp.Body.Close()
+	body := io.Reader(resp.Body)
+	if read != nil {
+		//error bodies of streamed requests are read without the overall timeout as well
+		body = newIdleReader(resp.Body, clientOptions.ReadTimeout, stopRead)
+	}
 
-		if err != nil {
-			return nil, 0, nil
+	//Mostly for HEAD requests
+	statusCode = resp.StatusCode
+	headers = resp.Header
+
+	// need to account for 403s with xray, or other 403s, 429? 204 is bad too (no content for docker)
+	switch resp.StatusCode {
+	case 200:
+		log.Debug("Received ", resp.StatusCode, " OK on ", method, " request for ", urlInput, " continuing")
+	case 201:
+		if method == "PUT" {
+			log.Debug("Received ", resp.StatusCode, " ", method, " request for ", urlInput, " continuing")
 		}
-		// need to account for 403s with xray, or other 403s, 429? 204 is bad too (no content for docker)
-		switch resp.StatusCode {
-		case 200:
-			log.Debug("Received ", resp.StatusCode, " OK on ", method, " request for ", urlInput, " continuing")
-		case 201:
-			if method == "PUT" {
-				log.Debug("Received ", resp.StatusCode, " ", method, " request for ", urlInput, " continuing")
-			}
-		case 403:
-			log.Error("Received ", resp.StatusCode, " Forbidden on ", method, " request for ", urlInput, " continuing")
-			// should we try retry here? probably not
-		case 404:
-			log.Debug("Received ", resp.StatusCode, " Not Found on ", method, " request for ", urlInput, " continuing")
-		case 429:
-			log.Error("Received ", resp.StatusCode, " Too Many Requests on ", method, " request for ", urlInput, ", sleeping then retrying, attempt ", retry)
-			time.Sleep(10 * time.Second)
-			GetRestAPI(method, auth, urlInput, userName, apiKey, providedfilepath, header, retry+1)
-		case 204:
-			if method == "GET" {
-				log.Error("Received ", resp.StatusCode, " No Content on ", method, " request for ", urlInput, ", sleeping then retrying")
-				time.Sleep(10 * time.Second)
-				GetRestAPI(method, auth, urlInput, userName, apiKey, providedfilepath, header, retry+1)
-			} else {
-				log.Debug("Received ", resp.StatusCode, " OK on ", method, " request for ", urlInput, " continuing")
-			}
-		case 500:
-			log.Error("Received ", resp.StatusCode, " Internal Server error on ", method, " request for ", urlInput, " failing out")
-			return nil, 0, nil
-		default:
-			log.Warn("Received ", resp.StatusCode, " on ", method, " request for ", urlInput, " continuing")
+	case 403:
+		log.Error("Received ", resp.StatusCode, " Forbidden on ", method, " request for ", urlInput, " continuing")
+		// should we try retry here? probably not
+	case 404:
+		log.Debug("Received ", resp.StatusCode, " Not Found on ", method, " request for ", urlInput, " continuing")
+	case 429:
+		log.Error("Received ", resp.StatusCode, " Too Many Requests on ", method, " request for ", urlInput, ", attempt ", attempt)
+		return nil, statusCode, headers, true, newRequestError(ErrRateLimited, method, urlInput, statusCode, nil)
+	case 204:
+		if method == "GET" {
+			log.Error("Received ", resp.StatusCode, " No Content on ", method, " request for ", urlInput, ", attempt ", attempt)
+			return nil, statusCode, headers, true, newRequestError(ErrServer, method, urlInput, statusCode, nil)
 		}
-		//Mostly for HEAD requests
-		statusCode := resp.StatusCode
-		headers := resp.Header
-
-		if providedfilepath != "" && method == "GET" {
-			// Create the file
-			out, err := os.Create(providedfilepath)
-			helpers.Check(err, false, "File create:"+providedfilepath, helpers.Trace())
-			defer out.Close()
-
-			//done := make(chan int64)
-			//go helpers.PrintDownloadPercent(done, filepath, int64(resp.ContentLength))
-			_, err = io.Copy(out, resp.Body)
-			helpers.Check(err, false, "The file copy:"+providedfilepath, helpers.Trace())
-		} else {
-			//maybe skip the download or retry if error here, like EOF
-			data, err := ioutil.ReadAll(resp.Body)
-			helpers.Check(err, false, "Data read:"+urlInput, helpers.Trace())
-			if err != nil {
-				log.Warn("Data Read on ", urlInput, " failed with:", err, ", sleeping then retrying, attempt:", retry)
-				time.Sleep(10 * time.Second)
+		log.Debug("Received ", resp.StatusCode, " OK on ", method, " request for ", urlInput, " continuing")
+	case 500:
+		log.Error("Received ", resp.StatusCode, " Internal Server error on ", method, " request for ", urlInput, " failing out")
+	case 502, 503, 504:
+		log.Error("Received ", resp.StatusCode, " ", http.StatusText(resp.StatusCode), " on ", method, " request for ", urlInput, ", attempt ", attempt)
+		return nil, statusCode, headers, true, newRequestError(ErrServer, method, urlInput, statusCode, nil)
+	default:
+		log.Warn("Received ", resp.StatusCode, " on ", method, " request for ", urlInput, " continuing")
+	}
 
-				GetRestAPI(method, auth, urlInput, userName, apiKey, providedfilepath, header, retry+1)
-			}
+	if read != nil && statusCode < 300 {
+		if err := read(body); err != nil {
+			return nil, statusCode, headers, false, newRequestError(streamErrorCategory(ctx, err), method, urlInput, statusCode, err)
+		}
+		return nil, statusCode, headers, false, nil
+	}
+	if providedfilepath != "" && method == "GET" {
+		// Create the file
+		out, err := os.Create(providedfilepath)
+		helpers.Check(err, false, "File create:"+providedfilepath, helpers.Trace())
+		if err != nil {
+			return nil, statusCode, headers, false, newRequestError(ErrRequest, method, urlInput, statusCode, err)
+		}
+		defer out.Close()
 
-			return data, statusCode, headers
+		//done := make(chan int64)
+		//go helpers.PrintDownloadPercent(done, filepath, int64(resp.ContentLength))
+		_, err = io.Copy(out, body)
+		helpers.Check(err, false, "The file copy:"+providedfilepath, helpers.Trace())
+		if err != nil {
+			return nil, statusCode, headers, false, newRequestError(ErrNetwork, method, urlInput, statusCode, err)
 		}
+		return nil, statusCode, headers, false, statusError(method, urlInput, statusCode)
+	}
+	data, err = ioutil.ReadAll(body)
+	helpers.Check(err, false, "Data read:"+urlInput, helpers.Trace())
+	if err != nil {
+		log.Warn("Data Read on ", urlInput, " failed with:", err, ", attempt:", attempt)
+		return nil, statusCode, headers, true, newRequestError(ErrNetwork, method, urlInput, statusCode, err)
+	}
+	log.Debug("Response body of ", method, " request for ", urlInput, ": ", helpers.TruncateBody(data))
+	if reqErr = statusError(method, urlInput, statusCode); reqErr != nil {
+		reqErr.Body = data
 	}
-	return nil, 0, nil
+	return data, statusCode, headers, false, reqErr
 }
 
-//Test if remote repository exists and is a remote
-func CheckTypeAndRepoParams(creds Creds) []IndexedRepo {
-	repoCheckData, repoStatusCode, _ := GetRestAPI("GET", true, creds.URL+"/artifactory/api/xrayRepo/getIndex", creds.Username, creds.Apikey, "", nil, 1)
-	if repoStatusCode != 200 {
-		log.Fatalf("Repo list does not exist.")
+//setAuth uses basic auth for user/API key pairs, and a Bearer token when no user is given
+func setAuth(req *http.Request, userName, apiKey string) {
+	if userName == "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+		return
 	}
-	var result []IndexedRepo
-	json.Unmarshal(repoCheckData, &result)
-	return result
+	req.SetBasicAuth(userName, apiKey)
 }
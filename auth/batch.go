@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"context"
+	"errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//ReindexOutcome is the result of submitting one artifact, Err is nil when Xray accepted it
+type ReindexOutcome struct {
+	Artifact Artifact
+	Err      error
+}
+
+//ReindexBatch submits artifacts in a single request. When Xray rejects the request as a whole because of some of
+//its artifacts, it is split in halves that are submitted again, down to single artifacts, so one bad artifact does
+//not fail the others. Xray versions taking one artifact per request report each artifact already and are not split
+func ReindexBatch(ctx context.Context, xray XrayClient, artifacts []Artifact) []ReindexOutcome {
+	if len(artifacts) == 0 {
+		return nil
+	}
+	results := xray.ForceReindex(ctx, artifacts)
+	err := batchError(results)
+	if len(artifacts) == 1 || !perArtifact(err) {
+		return results
+	}
+	half := len(artifacts) / 2
+	log.Warn("Batch of ", len(artifacts), " artifacts failed with ", err, ", retrying as batches of ", half, " and ", len(artifacts)-half)
+	return append(ReindexBatch(ctx, xray, artifacts[:half]), ReindexBatch(ctx, xray, artifacts[half:])...)
+}
+
+//batchError is the error every outcome failed with when the batch was rejected as a whole, nil otherwise
+func batchError(results []ReindexOutcome) error {
+	if len(results) == 0 {
+		return nil
+	}
+	err := results[0].Err
+	for _, result := range results[1:] {
+		if result.Err != err {
+			return nil
+		}
+	}
+	return err
+}
+
+//perArtifact reports whether err is Xray rejecting some of the submitted artifacts, which a smaller request
+//without them may get past. Credential, server, network, rate limiting and outage failures would fail every piece
+//the same way
+func perArtifact(err error) bool {
+	var reqErr *RequestError
+	if !errors.As(err, &reqErr) {
+		return false
+	}
+	switch reqErr.Category {
+	case ErrRequest, ErrNotFound:
+		return reqErr.StatusCode >= 400 && reqErr.StatusCode < 500
+	}
+	return false
+}
+
+func outcomes(artifacts []Artifact, err error) []ReindexOutcome {
+	results := make([]ReindexOutcome, len(artifacts))
+	for i := range artifacts {
+		results[i] = ReindexOutcome{Artifact: artifacts[i], Err: err}
+	}
+	return results
+}
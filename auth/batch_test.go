@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+//fakeXray rejects every request that submits one of the bad artifacts with err. perArtifact reports each artifact
+//on its own, as Xray 3.76 and later do
+type fakeXray struct {
+	XrayClient
+	bad         map[string]bool
+	err         error
+	perArtifact bool
+	requests    [][]string
+}
+
+func (x *fakeXray) ForceReindex(ctx context.Context, artifacts []Artifact) []ReindexOutcome {
+	var paths []string
+	var rejected bool
+	results := make([]ReindexOutcome, len(artifacts))
+	for i, artifact := range artifacts {
+		paths = append(paths, artifact.Path)
+		results[i].Artifact = artifact
+		if x.bad[artifact.Path] {
+			rejected = true
+			results[i].Err = x.err
+		}
+	}
+	x.requests = append(x.requests, paths)
+	if rejected && !x.perArtifact {
+		return outcomes(artifacts, x.err)
+	}
+	return results
+}
+
+func artifacts(paths ...string) []Artifact {
+	var artifacts []Artifact
+	for _, path := range paths {
+		artifacts = append(artifacts, Artifact{Repository: "npm-local", Path: path})
+	}
+	return artifacts
+}
+
+func TestReindexBatch(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		perArtifact bool
+		wantFailed  []string
+		wantCalls   int
+	}{
+		{"rejected is split", newRequestError(ErrRequest, "POST", "", 400, nil), false, []string{"/c"}, 5},
+		{"not found is split", newRequestError(ErrNotFound, "POST", "", 404, nil), false, []string{"/c"}, 5},
+		{"server error is not split", newRequestError(ErrServer, "POST", "", 500, nil), false, []string{"/a", "/b", "/c", "/d"}, 1},
+		{"rate limited is not split", newRequestError(ErrRateLimited, "POST", "", 429, nil), false, []string{"/a", "/b", "/c", "/d"}, 1},
+		{"auth error is not split", newRequestError(ErrAuth, "POST", "", 403, nil), false, []string{"/a", "/b", "/c", "/d"}, 1},
+		{"network error is not split", newRequestError(ErrNetwork, "POST", "", 0, errors.New("connection reset")), false, []string{"/a", "/b", "/c", "/d"}, 1},
+		{"per artifact is not split", newRequestError(ErrRequest, "POST", "", 400, nil), true, []string{"/c"}, 1},
+	}
+	//abcd is rejected, ab goes through, cd is rejected and split into c and d
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			xray := &fakeXray{bad: map[string]bool{"/c": true}, err: test.err, perArtifact: test.perArtifact}
+			results := ReindexBatch(context.Background(), xray, artifacts("/a", "/b", "/c", "/d"))
+			var failed []string
+			for i, result := range results {
+				if want := []string{"/a", "/b", "/c", "/d"}[i]; result.Artifact.Path != want {
+					t.Errorf("outcome %d is for %s, want %s", i, result.Artifact.Path, want)
+				}
+				if result.Err != nil {
+					failed = append(failed, result.Artifact.Path)
+				}
+			}
+			if !reflect.DeepEqual(failed, test.wantFailed) {
+				t.Errorf("failed %v, want %v", failed, test.wantFailed)
+			}
+			if len(xray.requests) != test.wantCalls {
+				t.Errorf("made %d requests, want %d: %v", len(xray.requests), test.wantCalls, xray.requests)
+			}
+		})
+	}
+}
+
+func TestReindexBatchEmpty(t *testing.T) {
+	xray := &fakeXray{}
+	if results := ReindexBatch(context.Background(), xray, nil); results != nil || len(xray.requests) != 0 {
+		t.Errorf("empty batch returned %v after %d requests", results, len(xray.requests))
+	}
+}
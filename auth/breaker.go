@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//BreakerOptions configures the circuit breaker around Xray calls. After Threshold consecutive failures
+//submissions pause and Xray is probed every ProbeInterval. Once the breaker has been open for MaxOpen
+//it trips for good and every Xray call fails. Rate limited calls neither count as failures nor reset the count.
+//A zero Threshold disables the breaker
+type BreakerOptions struct {
+	Threshold     int
+	ProbeInterval time.Duration
+	MaxOpen       time.Duration
+}
+
+var xrayBreaker = &circuitBreaker{}
+
+//SetBreakerOptions configures the Xray circuit breaker, resetting its state
+func SetBreakerOptions(opts BreakerOptions) {
+	xrayBreaker = &circuitBreaker{opts: opts}
+}
+
+//XrayUnavailable returns the error the Xray circuit breaker tripped with, nil while Xray is usable
+func XrayUnavailable() error {
+	xrayBreaker.mu.Lock()
+	defer xrayBreaker.mu.Unlock()
+	if xrayBreaker.tripped == nil {
+		return nil
+	}
+	return xrayBreaker.tripped
+}
+
+type circuitBreaker struct {
+	mu       sync.Mutex
+	opts     BreakerOptions
+	failures int
+	openedAt time.Time
+	lastErr  error
+	probing  bool
+	tripped  *RequestError
+}
+
+//allow blocks while the breaker is open, probing with health until it succeeds or MaxOpen has passed
+func (b *circuitBreaker) allow(ctx context.Context, health func(context.Context) error) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for {
+		if b.tripped != nil {
+			return b.tripped
+		}
+		if b.opts.Threshold <= 0 || b.failures < b.opts.Threshold {
+			return nil
+		}
+		if open := time.Since(b.openedAt); open >= b.opts.MaxOpen {
+			var url string
+			var lastErr *RequestError
+			if errors.As(b.lastErr, &lastErr) {
+				url = lastErr.URL
+			}
+			b.tripped = newRequestError(ErrCircuitOpen, "", url, 0, fmt.Errorf("Xray still failing after %s, last error: %v", open.Round(time.Millisecond), b.lastErr))
+			log.Error("Giving up on Xray: ", b.tripped)
+			return b.tripped
+		}
+		b.mu.Unlock()
+		slept := sleepContext(ctx, b.opts.ProbeInterval)
+		b.mu.Lock()
+		if !slept {
+			return newRequestError(ErrCancelled, "", "", 0, ctx.Err())
+		}
+		//one caller probes, the others keep waiting for its outcome
+		if b.probing || b.failures < b.opts.Threshold || b.tripped != nil {
+			continue
+		}
+		b.probing = true
+		b.mu.Unlock()
+		err := health(ctx)
+		b.mu.Lock()
+		b.probing = false
+		if err != nil {
+			b.lastErr = err
+			log.Warn("Xray health check failed, submissions stay paused: ", err)
+			continue
+		}
+		log.Info("Xray recovered after ", time.Since(b.openedAt).Round(time.Millisecond), ", resuming submissions")
+		b.failures = 0
+		return nil
+	}
+}
+
+//record counts consecutive server and network failures, any other outcome shows Xray is responding
+func (b *circuitBreaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch Category(err) {
+	case ErrServer, ErrNetwork:
+	case ErrCancelled, ErrCircuitOpen, ErrRateLimited:
+		//rate limiting is Xray pushing back, not failing, and says nothing about whether it recovered
+		return
+	default:
+		b.failures = 0
+		return
+	}
+	b.failures++
+	b.lastErr = err
+	if b.opts.Threshold > 0 && b.failures == b.opts.Threshold {
+		b.openedAt = time.Now()
+		log.Warn("Xray failed ", b.failures, " times in a row, pausing submissions and probing every ", b.opts.ProbeInterval, " for up to ", b.opts.MaxOpen)
+	}
+}
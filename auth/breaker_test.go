@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func serverError() error {
+	return newRequestError(ErrServer, "POST", "http://xray/api/v1/forceReindex", 500, nil)
+}
+
+func TestCircuitBreakerCountsConsecutiveFailures(t *testing.T) {
+	b := &circuitBreaker{opts: BreakerOptions{Threshold: 3, ProbeInterval: time.Millisecond, MaxOpen: time.Minute}}
+	healthy := func(context.Context) error { return nil }
+	b.record(serverError())
+	b.record(serverError())
+	//rate limiting neither counts nor resets
+	b.record(newRequestError(ErrRateLimited, "POST", "", 429, nil))
+	if b.failures != 2 {
+		t.Fatalf("failures = %d after a rate limited call, want 2", b.failures)
+	}
+	//any other answer shows Xray is responding
+	b.record(newRequestError(ErrRequest, "POST", "", 400, nil))
+	if b.failures != 0 {
+		t.Fatalf("failures = %d after a rejected request, want 0", b.failures)
+	}
+	for i := 0; i < 3; i++ {
+		b.record(serverError())
+	}
+	probed := false
+	if err := b.allow(context.Background(), func(ctx context.Context) error {
+		probed = true
+		return healthy(ctx)
+	}); err != nil {
+		t.Fatalf("allow returned %v once the probe succeeded", err)
+	}
+	if !probed || b.failures != 0 {
+		t.Errorf("probed %t, failures %d, want a probe that closes the breaker", probed, b.failures)
+	}
+}
+
+func TestCircuitBreakerTrips(t *testing.T) {
+	b := &circuitBreaker{opts: BreakerOptions{Threshold: 1, ProbeInterval: time.Millisecond, MaxOpen: 20 * time.Millisecond}}
+	b.record(serverError())
+	var probes int
+	err := b.allow(context.Background(), func(context.Context) error {
+		probes++
+		return serverError()
+	})
+	if Category(err) != ErrCircuitOpen {
+		t.Fatalf("allow returned %v, want a circuit-open error", err)
+	}
+	if probes == 0 {
+		t.Error("Xray was not probed before giving up")
+	}
+	//stays tripped
+	if err := b.allow(context.Background(), func(context.Context) error { return nil }); Category(err) != ErrCircuitOpen {
+		t.Errorf("allow returned %v after tripping, want a circuit-open error", err)
+	}
+}
+
+func TestCircuitBreakerCancelled(t *testing.T) {
+	b := &circuitBreaker{opts: BreakerOptions{Threshold: 1, ProbeInterval: time.Hour, MaxOpen: time.Hour}}
+	b.record(serverError())
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := b.allow(ctx, func(context.Context) error { return errors.New("not probed") })
+	if Category(err) != ErrCancelled {
+		t.Errorf("allow returned %v, want a cancelled error", err)
+	}
+}
+
+func TestCircuitBreakerDisabled(t *testing.T) {
+	b := &circuitBreaker{}
+	for i := 0; i < 10; i++ {
+		b.record(serverError())
+	}
+	if err := b.allow(context.Background(), nil); err != nil {
+		t.Errorf("disabled breaker returned %v", err)
+	}
+}
@@ -0,0 +1,202 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/lorenyeung/forceReindexXray/helpers"
+)
+
+//interaction is one request/response pair of a cassette, stored one per line
+type interaction struct {
+	Method          string      `json:"method"`
+	URL             string      `json:"url"`
+	RequestHeaders  http.Header `json:"requestHeaders,omitempty"`
+	RequestBody     string      `json:"requestBody,omitempty"`
+	StatusCode      int         `json:"statusCode,omitempty"`
+	ResponseHeaders http.Header `json:"responseHeaders,omitempty"`
+	ResponseBody    string      `json:"responseBody,omitempty"`
+	Error           string      `json:"error,omitempty"`
+}
+
+func (i interaction) key() string {
+	return i.Method + " " + i.URL + "\n" + i.RequestBody
+}
+
+var cassette interface {
+	wrap(next http.RoundTripper) http.RoundTripper
+	close() error
+}
+
+//RecordTo writes every request and response to path, with credentials redacted from headers and bodies
+func RecordTo(path string) error {
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	cassette = &recorder{out: out}
+	httpClient = newClient()
+	return nil
+}
+
+//ReplayFrom serves every request from a cassette written by RecordTo, without any network access
+func ReplayFrom(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	player := &player{recorded: make(map[string][]interaction)}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(data)+1)
+	for line := 1; scanner.Scan(); line++ {
+		var recorded interaction
+		if err := json.Unmarshal(scanner.Bytes(), &recorded); err != nil {
+			return fmt.Errorf("%s line %d: %w", path, line, err)
+		}
+		player.recorded[recorded.key()] = append(player.recorded[recorded.key()], recorded)
+	}
+	cassette = player
+	httpClient = newClient()
+	return nil
+}
+
+//Replaying reports whether requests are served from a cassette
+func Replaying() bool {
+	_, ok := cassette.(*player)
+	return ok
+}
+
+//CloseCassette closes a cassette being recorded
+func CloseCassette() error {
+	if cassette == nil {
+		return nil
+	}
+	return cassette.close()
+}
+
+//recorder passes requests through and appends them to the cassette. Every interaction is written
+//straight away so the cassette survives fatal errors
+type recorder struct {
+	mu   sync.Mutex
+	out  *os.File
+	next http.RoundTripper
+}
+
+func (r *recorder) wrap(next http.RoundTripper) http.RoundTripper {
+	r.next = next
+	return r
+}
+
+func (r *recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	recorded := interaction{Method: req.Method, URL: req.URL.String(), RequestHeaders: helpers.RedactHeaders(req.Header)}
+	if req.Body != nil {
+		body, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		recorded.RequestBody = helpers.Redact(string(body))
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	resp, err := r.next.RoundTrip(req)
+	if err != nil {
+		recorded.Error = err.Error()
+		r.write(recorded)
+		return nil, err
+	}
+	recorded.StatusCode = resp.StatusCode
+	recorded.ResponseHeaders = helpers.RedactHeaders(resp.Header)
+	//the body is recorded as the caller reads it, so streamed bodies keep their read timeout
+	resp.Body = newCaptureBody(resp.Body, func(body []byte, err error) {
+		recorded.ResponseBody = helpers.Redact(string(body))
+		if err != nil {
+			recorded.Error = err.Error()
+		}
+		r.write(recorded)
+	})
+	return resp, nil
+}
+
+func (r *recorder) write(recorded interaction) {
+	line, err := json.Marshal(recorded)
+	if err != nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.out.Write(append(line, '\n'))
+}
+
+func (r *recorder) close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.out.Close()
+}
+
+//player answers requests from the cassette. Identical requests get their recorded responses in order,
+//the last one is repeated once they run out
+type player struct {
+	mu       sync.Mutex
+	recorded map[string][]interaction
+}
+
+func (p *player) wrap(next http.RoundTripper) http.RoundTripper {
+	return p
+}
+
+func (p *player) RoundTrip(req *http.Request) (*http.Response, error) {
+	wanted := interaction{Method: req.Method, URL: req.URL.String()}
+	if req.Body != nil {
+		body, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		//recorded bodies are redacted, so the request is too before looking it up
+		wanted.RequestBody = helpers.Redact(string(body))
+	}
+
+	p.mu.Lock()
+	queue := p.recorded[wanted.key()]
+	if len(queue) == 0 {
+		p.mu.Unlock()
+		return nil, errors.New("no recorded response for " + wanted.Method + " " + wanted.URL)
+	}
+	recorded := queue[0]
+	if len(queue) > 1 {
+		p.recorded[wanted.key()] = queue[1:]
+	}
+	p.mu.Unlock()
+
+	if recorded.StatusCode == 0 && recorded.Error != "" {
+		return nil, errors.New(recorded.Error)
+	}
+	header := recorded.ResponseHeaders
+	if header == nil {
+		header = make(http.Header)
+	}
+	return &http.Response{
+		Status:        strconv.Itoa(recorded.StatusCode) + " " + http.StatusText(recorded.StatusCode),
+		StatusCode:    recorded.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader([]byte(recorded.ResponseBody))),
+		ContentLength: int64(len(recorded.ResponseBody)),
+		Request:       req,
+	}, nil
+}
+
+func (p *player) close() error {
+	return nil
+}
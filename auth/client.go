@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+)
+
+//ClientOptions tunes the HTTP client shared by every request
+type ClientOptions struct {
+	ConnectTimeout time.Duration
+	ReadTimeout    time.Duration
+	Timeout        time.Duration
+	MaxConns       int
+	KeepAlive      time.Duration
+}
+
+var clientOptions = ClientOptions{
+	ConnectTimeout: 10 * time.Second,
+	ReadTimeout:    time.Minute,
+	Timeout:        10 * time.Minute,
+	MaxConns:       5,
+	KeepAlive:      30 * time.Second,
+}
+
+var tlsConfig *tls.Config
+
+var httpClient = newClient()
+
+//SetClientOptions rebuilds the shared client with opts
+func SetClientOptions(opts ClientOptions) {
+	if opts.MaxConns < 1 {
+		opts.MaxConns = 1
+	}
+	clientOptions = opts
+	httpClient = newClient()
+}
+
+//newClient builds a client from the current options and TLS configuration. A zero timeout means no limit
+func newClient() *http.Client {
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   clientOptions.ConnectTimeout,
+			KeepAlive: clientOptions.KeepAlive,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		TLSClientConfig:       tlsConfig,
+		TLSHandshakeTimeout:   clientOptions.ConnectTimeout,
+		ResponseHeaderTimeout: clientOptions.ReadTimeout,
+		ExpectContinueTimeout: time.Second,
+		//one idle connection per worker, plus one for the main loop
+		MaxIdleConns:        clientOptions.MaxConns + 1,
+		MaxIdleConnsPerHost: clientOptions.MaxConns + 1,
+		IdleConnTimeout:     90 * time.Second,
+	}
+	var roundTripper http.RoundTripper = transport
+	if cassette != nil {
+		roundTripper = cassette.wrap(transport)
+	}
+	if httpLog != nil {
+		roundTripper = httpLog.wrap(roundTripper)
+	}
+	if readOnly {
+		roundTripper = readOnlyTransport{next: roundTripper}
+	}
+	return &http.Client{Transport: roundTripper, Timeout: clientOptions.Timeout}
+}
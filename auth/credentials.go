@@ -0,0 +1,307 @@
+package auth
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lorenyeung/forceReindexXray/helpers"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+//Environment variables credentials are read from
+const (
+	EnvURL         = "JFROG_URL"
+	EnvUser        = "JFROG_USER"
+	EnvPassword    = "JFROG_PASSWORD"
+	EnvAPIKey      = "JFROG_APIKEY"
+	EnvAccessToken = "JFROG_ACCESS_TOKEN"
+)
+
+//jfrogCLIServer is a server entry of the JFrog CLI configuration
+type jfrogCLIServer struct {
+	ServerID       string `json:"serverId"`
+	URL            string `json:"url"`
+	ArtifactoryURL string `json:"artifactoryUrl"`
+	XrayURL        string `json:"xrayUrl"`
+	User           string `json:"user"`
+	Password       string `json:"password"`
+	APIKey         string `json:"apiKey"`
+	AccessToken    string `json:"accessToken"`
+	IsDefault      bool   `json:"isDefault"`
+}
+
+//jfrogCLIConfig covers the current "servers" layout as well as the older "artifactory" one
+type jfrogCLIConfig struct {
+	Servers     []jfrogCLIServer `json:"servers"`
+	Artifactory []jfrogCLIServer `json:"artifactory"`
+	Enc         bool             `json:"enc"`
+}
+
+//ResolveCreds populates Creds without prompting where possible. URLs and secrets are looked up in order from
+//flags, environment variables, -passwordStdin, ~/.netrc and the JFrog CLI server config. The JFrog CLI config is
+//only read with -serverId or when nothing before it had the URL or secret. The terminal prompt is only used as a
+//last resort when stdin is a terminal
+func ResolveCreds(flags helpers.Flags) (Creds, error) {
+	var creds Creds
+	cli := &cliServer{id: flags.ServerIDVar}
+	if flags.ServerIDVar != "" {
+		if _, err := cli.get(); err != nil {
+			return creds, err
+		}
+	}
+
+	creds.URL = firstNonEmpty(flags.URLVar, os.Getenv(EnvURL))
+	artifactoryURL, xrayURL := flags.ArtifactoryURLVar, flags.XrayURLVar
+	if creds.URL == "" && artifactoryURL == "" && xrayURL == "" {
+		server, err := cli.get()
+		if err != nil {
+			return creds, err
+		}
+		if server != nil {
+			log.Debug("Using URLs of JFrog CLI server ", server.ServerID)
+			creds.URL, artifactoryURL, xrayURL = server.URL, server.ArtifactoryURL, server.XrayURL
+		}
+	}
+	if creds.URL == "" && (artifactoryURL == "" || xrayURL == "") {
+		return creds, errors.New("please specify -url (or -artifactoryUrl AND -xrayUrl), set " + EnvURL + " or configure a JFrog CLI server")
+	}
+	creds.SetServiceURLs(artifactoryURL, xrayURL)
+
+	user := firstNonEmpty(flags.UsernameVar, os.Getenv(EnvUser))
+	found, isToken, source, err := resolveSecret(flags, user, creds.ArtifactoryURL, cli)
+	if err != nil {
+		return creds, err
+	}
+	if source == "netrc" || (source == "JFrog CLI" && user == "") {
+		user = found.user
+	}
+	log.Debug("Using credentials from ", source)
+
+	creds.Apikey = found.password
+	if isToken {
+		helpers.RegisterSecret("", creds.Apikey)
+		//access tokens are sent as a Bearer header, which requires an empty username
+		return creds, nil
+	}
+	if user == "" {
+		return creds, errors.New("please specify -user, set " + EnvUser + " or use -token/-tokenFile")
+	}
+	creds.Username = user
+	helpers.RegisterSecret(user, creds.Apikey)
+	return creds, nil
+}
+
+//cliServer loads the JFrog CLI server on first use, so runs that do not need it never read the config
+type cliServer struct {
+	id     string
+	loaded bool
+	server *jfrogCLIServer
+	err    error
+}
+
+func (c *cliServer) get() (*jfrogCLIServer, error) {
+	if !c.loaded {
+		c.loaded = true
+		c.server, c.err = findJFrogCLIServer(c.id)
+	}
+	return c.server, c.err
+}
+
+type credential struct {
+	user     string
+	password string
+}
+
+//resolveSecret finds the API key, password or access token, reporting whether it is a token and where it came from
+func resolveSecret(flags helpers.Flags, user, artifactoryURL string, cli *cliServer) (credential, bool, string, error) {
+	if flags.TokenFileVar != "" {
+		tokenData, err := ioutil.ReadFile(flags.TokenFileVar)
+		if err != nil {
+			return credential{}, false, "", fmt.Errorf("invalid token file: %w", err)
+		}
+		return credential{password: strings.TrimSpace(string(tokenData))}, true, "-tokenFile", nil
+	}
+	if flags.TokenVar != "" {
+		return credential{password: flags.TokenVar}, true, "-token", nil
+	}
+	if flags.ApikeyVar != "" {
+		return credential{password: flags.ApikeyVar}, false, "-apikey", nil
+	}
+	if token := os.Getenv(EnvAccessToken); token != "" {
+		return credential{password: token}, true, EnvAccessToken, nil
+	}
+	if password := firstNonEmpty(os.Getenv(EnvPassword), os.Getenv(EnvAPIKey)); password != "" {
+		return credential{password: password}, false, EnvPassword + "/" + EnvAPIKey, nil
+	}
+	if flags.PasswordStdinVar {
+		password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		password = strings.TrimRight(password, "\r\n")
+		if password == "" {
+			return credential{}, false, "", fmt.Errorf("no password received on stdin: %v", err)
+		}
+		//without a user, what was piped in can only be an access token
+		return credential{password: password}, user == "", "stdin", nil
+	}
+	if login, password, ok := netrcLookup(artifactoryURL); ok && (user == "" || user == login) {
+		return credential{user: login, password: password}, false, "netrc", nil
+	}
+	server, err := cli.get()
+	if err != nil {
+		return credential{}, false, "", err
+	}
+	if server != nil && (user == "" || user == server.User || server.AccessToken != "") {
+		switch {
+		case server.AccessToken != "":
+			return credential{password: server.AccessToken}, true, "JFrog CLI", nil
+		case server.User != "" && firstNonEmpty(server.Password, server.APIKey) != "":
+			return credential{user: server.User, password: firstNonEmpty(server.Password, server.APIKey)}, false, "JFrog CLI", nil
+		}
+	}
+	if Replaying() {
+		//the cassette has credentials redacted, any placeholder will do
+		return credential{password: "replay"}, true, "replay cassette", nil
+	}
+	if terminal.IsTerminal(int(os.Stdin.Fd())) {
+		fmt.Println("Enter password or API key: ")
+		password, err := terminal.ReadPassword(int(os.Stdin.Fd()))
+		if err != nil {
+			return credential{}, false, "", fmt.Errorf("reading password: %w", err)
+		}
+		return credential{password: string(password)}, false, "prompt", nil
+	}
+	return credential{}, false, "", errors.New("no credentials found, use -apikey, -token, -tokenFile, -passwordStdin, " + EnvAccessToken + ", " + EnvPassword + ", ~/.netrc or a JFrog CLI server")
+}
+
+//netrcLookup returns the login and password for the host of rawURL from $NETRC or ~/.netrc
+func netrcLookup(rawURL string) (string, string, bool) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Hostname() == "" {
+		return "", "", false
+	}
+	path := os.Getenv("NETRC")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", "", false
+		}
+		path = filepath.Join(home, ".netrc")
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", "", false
+	}
+	return parseNetrc(string(data), parsed.Hostname())
+}
+
+//parseNetrc finds the entry for host, falling back to the default entry
+func parseNetrc(data, host string) (string, string, bool) {
+	var login, password, defLogin, defPassword string
+	var inHost, inDefault, found, foundDefault bool
+	fields := strings.Fields(data)
+	for i := 0; i < len(fields); i++ {
+		switch fields[i] {
+		case "machine":
+			if inHost {
+				return login, password, password != ""
+			}
+			i++
+			inHost = i < len(fields) && fields[i] == host
+			inDefault = false
+			found = found || inHost
+		case "default":
+			if inHost {
+				return login, password, password != ""
+			}
+			inDefault = true
+			foundDefault = true
+		case "login", "password":
+			if i+1 >= len(fields) {
+				break
+			}
+			value := fields[i+1]
+			switch {
+			case inHost && fields[i] == "login":
+				login = value
+			case inHost:
+				password = value
+			case inDefault && fields[i] == "login":
+				defLogin = value
+			case inDefault:
+				defPassword = value
+			}
+			i++
+		case "macdef":
+			//macro bodies run until a blank line, which strings.Fields does not keep, so stop here
+			fields = fields[:i]
+		}
+	}
+	if found {
+		return login, password, password != ""
+	}
+	return defLogin, defPassword, foundDefault && defPassword != ""
+}
+
+//findJFrogCLIServer loads serverID, or the default server when serverID is empty, from the JFrog CLI config.
+//A missing config is not an error unless a server ID was asked for
+func findJFrogCLIServer(serverID string) (*jfrogCLIServer, error) {
+	dir := os.Getenv("JFROG_CLI_HOME_DIR")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, nil
+		}
+		dir = filepath.Join(home, ".jfrog")
+	}
+	var data []byte
+	for _, name := range []string{"jfrog-cli.conf.v6", "jfrog-cli.conf.v5", "jfrog-cli.conf"} {
+		var err error
+		if data, err = ioutil.ReadFile(filepath.Join(dir, name)); err == nil {
+			break
+		}
+	}
+	if data == nil {
+		if serverID != "" {
+			return nil, fmt.Errorf("JFrog CLI server %s requested but no JFrog CLI config found in %s", serverID, dir)
+		}
+		return nil, nil
+	}
+
+	var config jfrogCLIConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("reading JFrog CLI config: %w", err)
+	}
+	if config.Enc {
+		log.Warn("JFrog CLI config is encrypted with a master key, its credentials cannot be used")
+	}
+	servers := append(config.Servers, config.Artifactory...)
+	for i := range servers {
+		if (serverID != "" && servers[i].ServerID == serverID) || (serverID == "" && servers[i].IsDefault) {
+			if config.Enc {
+				servers[i].Password, servers[i].APIKey, servers[i].AccessToken = "", "", ""
+			}
+			return &servers[i], nil
+		}
+	}
+	if serverID != "" {
+		return nil, fmt.Errorf("JFrog CLI server %s not found", serverID)
+	}
+	return nil, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,33 @@
+package auth
+
+import "testing"
+
+func TestParseNetrc(t *testing.T) {
+	const netrc = `machine other.example.com login bob password bobpass
+machine arti.example.com
+  login alice
+  password alicepass
+default login anon password anonpass
+`
+	tests := []struct {
+		name, data, host string
+		login, password  string
+		ok               bool
+	}{
+		{"host", netrc, "arti.example.com", "alice", "alicepass", true},
+		{"first host", netrc, "other.example.com", "bob", "bobpass", true},
+		{"default", netrc, "unknown.example.com", "anon", "anonpass", true},
+		{"no default", "machine other.example.com login bob password bobpass", "arti.example.com", "", "", false},
+		{"no password", "machine arti.example.com login alice", "arti.example.com", "alice", "", false},
+		{"macdef", "machine arti.example.com login alice password alicepass macdef init\ncd /tmp\n\nmachine evil login x password y", "arti.example.com", "alice", "alicepass", true},
+		{"empty", "", "arti.example.com", "", "", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			login, password, ok := parseNetrc(test.data, test.host)
+			if login != test.login || password != test.password || ok != test.ok {
+				t.Errorf("got %q %q %t, want %q %q %t", login, password, ok, test.login, test.password, test.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+//ErrorCategory groups request failures so callers can report and count them distinctly
+type ErrorCategory string
+
+//Request failure categories
+const (
+	ErrNetwork     ErrorCategory = "network"
+	ErrAuth        ErrorCategory = "auth"
+	ErrNotFound    ErrorCategory = "not-found"
+	ErrServer      ErrorCategory = "server"
+	ErrRateLimited ErrorCategory = "rate-limited"
+	ErrDecode      ErrorCategory = "decode"
+	ErrRequest     ErrorCategory = "request"
+	ErrCancelled   ErrorCategory = "cancelled"
+	ErrCircuitOpen ErrorCategory = "circuit-open"
+)
+
+//RequestError describes a failed Artifactory or Xray call
+type RequestError struct {
+	Category   ErrorCategory
+	Method     string
+	URL        string
+	StatusCode int
+	Attempts   int
+	Body       []byte
+	Err        error
+}
+
+func newRequestError(category ErrorCategory, method, url string, statusCode int, err error) *RequestError {
+	return &RequestError{Category: category, Method: method, URL: url, StatusCode: statusCode, Err: err}
+}
+
+func (e *RequestError) Error() string {
+	target := e.URL
+	if e.Method != "" {
+		target = e.Method + " " + target
+	}
+	msg := fmt.Sprintf("%s error on %s", e.Category, target)
+	if e.StatusCode != 0 {
+		msg += fmt.Sprintf(": HTTP %d", e.StatusCode)
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	if e.Attempts > 1 {
+		msg += fmt.Sprintf(" (after %d attempts)", e.Attempts)
+	}
+	return msg
+}
+
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
+//Category returns the category of err, or an empty category if err is not a *RequestError
+func Category(err error) ErrorCategory {
+	var reqErr *RequestError
+	if errors.As(err, &reqErr) {
+		return reqErr.Category
+	}
+	return ""
+}
+
+//statusError maps an unsuccessful status code to a *RequestError, or nil for success
+func statusError(method, url string, statusCode int) *RequestError {
+	var category ErrorCategory
+	switch {
+	case statusCode < 300:
+		return nil
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		category = ErrAuth
+	case statusCode == http.StatusNotFound:
+		category = ErrNotFound
+	case statusCode == http.StatusTooManyRequests:
+		category = ErrRateLimited
+	case statusCode >= 500:
+		category = ErrServer
+	default:
+		category = ErrRequest
+	}
+	return newRequestError(category, method, url, statusCode, nil)
+}
+
+//DecodeJSON unmarshals a response from url into v, reporting failures as decode errors
+func DecodeJSON(data []byte, v interface{}, url string) error {
+	if err := json.Unmarshal(data, v); err != nil {
+		return newRequestError(ErrDecode, "", url, 0, err)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lorenyeung/forceReindexXray/helpers"
+)
+
+var httpLog *httpLogger
+
+//LogHTTPTo traces every request and response to path with full bodies. Credential headers and registered
+//secrets are masked
+func LogHTTPTo(path string) error {
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	httpLog = &httpLogger{out: out}
+	httpClient = newClient()
+	return nil
+}
+
+//CloseHTTPLog closes the -logHttp file
+func CloseHTTPLog() error {
+	if httpLog == nil {
+		return nil
+	}
+	return httpLog.close()
+}
+
+type httpLogger struct {
+	mu   sync.Mutex
+	out  *os.File
+	next http.RoundTripper
+}
+
+//wrap returns l itself, so writes and CloseHTTPLog share one mutex
+func (l *httpLogger) wrap(next http.RoundTripper) http.RoundTripper {
+	l.next = next
+	return l
+}
+
+func (l *httpLogger) RoundTrip(req *http.Request) (*http.Response, error) {
+	var trace strings.Builder
+	start := time.Now()
+	fmt.Fprintf(&trace, "> %s %s\n", req.Method, req.URL)
+	writeHeaders(&trace, "> ", req.Header)
+	if req.Body != nil {
+		body, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		writeBody(&trace, "> ", body)
+	}
+
+	resp, err := l.next.RoundTrip(req)
+	elapsed := time.Since(start).Round(time.Millisecond)
+	if err != nil {
+		fmt.Fprintf(&trace, "< error after %s: %v\n", elapsed, err)
+		l.write(trace.String())
+		return nil, err
+	}
+	fmt.Fprintf(&trace, "< %s (%s)\n", resp.Status, elapsed)
+	writeHeaders(&trace, "< ", resp.Header)
+	//the body is traced as the caller reads it, so streamed bodies keep their read timeout
+	resp.Body = newCaptureBody(resp.Body, func(body []byte, err error) {
+		writeBody(&trace, "< ", body)
+		if err != nil {
+			fmt.Fprintf(&trace, "< error reading body: %v\n", err)
+		}
+		l.write(trace.String())
+	})
+	return resp, nil
+}
+
+func (l *httpLogger) write(trace string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fmt.Fprintf(l.out, "%s\n%s\n", time.Now().Format("2006-01-02 15:04:05.000"), helpers.Redact(trace))
+}
+
+func (l *httpLogger) close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.out.Close()
+}
+
+func writeHeaders(trace *strings.Builder, prefix string, headers http.Header) {
+	var names []string
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range headers[name] {
+			fmt.Fprintf(trace, "%s%s: %s\n", prefix, name, helpers.RedactHeader(name, value))
+		}
+	}
+}
+
+func writeBody(trace *strings.Builder, prefix string, body []byte) {
+	if len(body) == 0 {
+		return
+	}
+	fmt.Fprintf(trace, "%s\n%s\n", prefix, body)
+}
@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+//PreflightCheck is one line of the preflight checklist, Err is nil when it passed
+type PreflightCheck struct {
+	Name   string
+	Detail string
+	Err    error
+}
+
+//Preflight checks Artifactory and Xray are up, the user may call the Xray endpoints a run needs and there are
+//repositories to index. The reindex permission is only checked when reindex is set, as runs that only report on the
+//index status never submit. The indexed repositories are returned for the run to use
+func Preflight(ctx context.Context, artifactory ArtifactoryClient, xray XrayClient, reindex bool) ([]PreflightCheck, []IndexedRepo) {
+	var checks []PreflightCheck
+	check := func(name string, err error, detail string) {
+		checks = append(checks, PreflightCheck{Name: name, Detail: detail, Err: err})
+	}
+
+	check("Artifactory ping", artifactory.Ping(ctx), "")
+	check("Xray ping", xray.Ping(ctx), "")
+	switch {
+	case readOnly:
+		check("Xray reindex permission", nil, "skipped, read-only run")
+	case !reindex:
+		check("Xray reindex permission", nil, "skipped, -indexed run")
+	default:
+		check("Xray reindex permission", xray.ReindexAccess(ctx), "")
+	}
+	check("Xray artifact status permission", xray.StatusAccess(ctx), "")
+	repos, err := artifactory.IndexedRepos(ctx)
+	if err == nil && len(repos) == 0 {
+		err = errors.New("no repositories are set for Xray indexing")
+	}
+	check("Indexed repositories", err, fmt.Sprint(len(repos), " repos"))
+	return checks, repos
+}
+
+//PreflightPassed reports whether every check passed
+func PreflightPassed(checks []PreflightCheck) bool {
+	for _, check := range checks {
+		if check.Err != nil {
+			return false
+		}
+	}
+	return true
+}
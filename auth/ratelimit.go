@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+//RateLimits caps requests per second, separately for Artifactory and Xray. A zero rate means unlimited
+type RateLimits struct {
+	ArtifactoryRate  float64
+	ArtifactoryBurst int
+	XrayRate         float64
+	XrayBurst        int
+}
+
+var artifactoryLimiter, xrayLimiter *rateLimiter
+
+//SetRateLimits applies limits to every subsequent request, including retries
+func SetRateLimits(limits RateLimits) {
+	artifactoryLimiter = newRateLimiter(limits.ArtifactoryRate, limits.ArtifactoryBurst)
+	xrayLimiter = newRateLimiter(limits.XrayRate, limits.XrayBurst)
+}
+
+//Service is the JFrog service a request is sent to, selecting the rate limiter it waits on
+type Service string
+
+//Services a request can be sent to
+const (
+	ServiceArtifactory Service = "artifactory"
+	ServiceXray        Service = "xray"
+)
+
+//limiterFor picks the limiter of service
+func limiterFor(service Service) *rateLimiter {
+	if service == ServiceXray {
+		return xrayLimiter
+	}
+	return artifactoryLimiter
+}
+
+//rateLimiter is a token bucket shared by all workers
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+//wait blocks until a request may be sent, returning false if ctx is cancelled first. A nil limiter never blocks
+func (l *rateLimiter) wait(ctx context.Context) bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	//take the token now, going negative reserves it for later callers to queue behind
+	l.tokens--
+	delay := time.Duration(0)
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+	if delay == 0 {
+		return true
+	}
+	if !sleepContext(ctx, delay) {
+		//hand back the reservation
+		l.mu.Lock()
+		l.tokens++
+		l.mu.Unlock()
+		return false
+	}
+	return true
+}
@@ -0,0 +1,45 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterWait(t *testing.T) {
+	l := newRateLimiter(20, 2)
+	start := time.Now()
+	//the burst goes through at once, the next two wait a token each
+	for i := 0; i < 4; i++ {
+		if !l.wait(context.Background()) {
+			t.Fatal("wait was cancelled")
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond || elapsed > time.Second {
+		t.Errorf("4 requests at 20/s with a burst of 2 took %s, want about 100ms", elapsed)
+	}
+}
+
+func TestRateLimiterCancelled(t *testing.T) {
+	l := newRateLimiter(1, 1)
+	l.wait(context.Background())
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if l.wait(ctx) {
+		t.Error("wait went through a cancelled context")
+	}
+	//the reservation is handed back
+	if l.tokens < -0.1 {
+		t.Errorf("tokens = %f after a cancelled wait, want about 0", l.tokens)
+	}
+}
+
+func TestRateLimiterUnlimited(t *testing.T) {
+	if l := newRateLimiter(0, 5); l != nil {
+		t.Fatalf("rate 0 returned a limiter %+v", l)
+	}
+	var l *rateLimiter
+	if !l.wait(context.Background()) {
+		t.Error("nil limiter blocked")
+	}
+}
@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var readOnly bool
+
+//readOnlyPOSTs are POST endpoints that only read, allowed in read-only mode
+var readOnlyPOSTs = []string{"/api/v1/artifact/status", "/api/search/aql"}
+
+//SetReadOnly blocks every request that could change anything on the server, for -dryRun. Only GET and HEAD
+//requests and the POST endpoints that only read are sent
+func SetReadOnly(enabled bool) {
+	readOnly = enabled
+	httpClient = newClient()
+}
+
+//readOnlyTransport refuses mutating requests before they reach the network
+type readOnlyTransport struct {
+	next http.RoundTripper
+}
+
+func (t readOnlyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !allowedReadOnly(req.Method, req.URL.Path) {
+		log.Error("Blocked ", req.Method, " request for ", req.URL, " in read-only mode")
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, errors.New("blocked in read-only mode")
+	}
+	return t.next.RoundTrip(req)
+}
+
+func allowedReadOnly(method, path string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead:
+		return true
+	case http.MethodPost:
+		for _, endpoint := range readOnlyPOSTs {
+			if strings.HasSuffix(path, endpoint) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,24 @@
+package auth
+
+import "testing"
+
+func TestAllowedReadOnly(t *testing.T) {
+	tests := []struct {
+		method, path string
+		want         bool
+	}{
+		{"GET", "/artifactory/api/storage/npm-local", true},
+		{"HEAD", "/artifactory/api/system/ping", true},
+		{"POST", "/xray/api/v1/artifact/status", true},
+		{"POST", "/artifactory/api/search/aql", true},
+		{"POST", "/xray/api/v1/forceReindex", false},
+		{"POST", "/xray/api/v2/index", false},
+		{"PUT", "/artifactory/npm-local/a.tgz", false},
+		{"DELETE", "/artifactory/npm-local/a.tgz", false},
+	}
+	for _, test := range tests {
+		if got := allowedReadOnly(test.method, test.path); got != test.want {
+			t.Errorf("allowedReadOnly(%s, %s) = %t, want %t", test.method, test.path, got, test.want)
+		}
+	}
+}
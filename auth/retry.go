@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+//RetryPolicy controls how many times and how long GetRestAPIContext waits between failed attempts
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+//NoRetry passed as the retry argument of a request makes a single attempt whatever the retry policy, for health
+//checks and probes that are repeated by their caller
+const NoRetry = -1
+
+var retryPolicy = RetryPolicy{MaxAttempts: 6, BaseDelay: time.Second, MaxDelay: time.Minute}
+
+//SetRetryPolicy replaces the retry policy used by every request
+func SetRetryPolicy(policy RetryPolicy) {
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+	if policy.MaxDelay < policy.BaseDelay {
+		policy.MaxDelay = policy.BaseDelay
+	}
+	retryPolicy = policy
+}
+
+//backoff returns the wait before retry n (starting at 1), exponential with jitter unless the server asked for a delay
+func (p RetryPolicy) backoff(n int, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		return retryAfter
+	}
+	delay := p.BaseDelay
+	for i := 1; i < n && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	//equal jitter, wait between half and the full delay
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(delay-half)+1))
+}
+
+//parseRetryAfter reads the Retry-After header, either in seconds or as an HTTP date. It is capped at the policy's
+//MaxDelay so a server asking for an hour cannot stall a worker that long on every attempt
+func parseRetryAfter(headers http.Header) time.Duration {
+	wait := retryAfter(headers)
+	if wait > retryPolicy.MaxDelay {
+		return retryPolicy.MaxDelay
+	}
+	return wait
+}
+
+func retryAfter(headers http.Header) time.Duration {
+	value := headers.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(date); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
+
+//isRetryableError reports whether a transport error is likely transient, such as a reset connection
+func isRetryableError(err error) bool {
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	return isTimeout(err)
+}
+
+//isTimeout reports whether err is a connect, read or overall client timeout
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 6, BaseDelay: time.Second, MaxDelay: 5 * time.Second}
+	tests := []struct {
+		n          int
+		retryAfter time.Duration
+		min, max   time.Duration
+	}{
+		{1, 0, 500 * time.Millisecond, time.Second},
+		{2, 0, time.Second, 2 * time.Second},
+		{3, 0, 2 * time.Second, 4 * time.Second},
+		//capped at MaxDelay
+		{4, 0, 2500 * time.Millisecond, 5 * time.Second},
+		{10, 0, 2500 * time.Millisecond, 5 * time.Second},
+		//the server's delay is used as is
+		{1, 3 * time.Second, 3 * time.Second, 3 * time.Second},
+	}
+	for _, test := range tests {
+		for i := 0; i < 20; i++ {
+			if wait := policy.backoff(test.n, test.retryAfter); wait < test.min || wait > test.max {
+				t.Errorf("backoff(%d, %s) = %s, want between %s and %s", test.n, test.retryAfter, wait, test.min, test.max)
+			}
+		}
+	}
+	if wait := (RetryPolicy{}).backoff(1, 0); wait != 0 {
+		t.Errorf("backoff without a delay = %s, want 0", wait)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	saved := retryPolicy
+	defer func() { retryPolicy = saved }()
+	SetRetryPolicy(RetryPolicy{MaxAttempts: 2, BaseDelay: time.Second, MaxDelay: time.Minute})
+
+	tests := []struct {
+		name, value string
+		min, max    time.Duration
+	}{
+		{"missing", "", 0, 0},
+		{"seconds", "7", 7 * time.Second, 7 * time.Second},
+		{"capped", "3600", time.Minute, time.Minute},
+		{"negative", "-5", 0, 0},
+		{"invalid", "soon", 0, 0},
+		{"date", time.Now().Add(30 * time.Second).UTC().Format(http.TimeFormat), 28 * time.Second, 30 * time.Second},
+		{"past date", time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), 0, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			headers := http.Header{}
+			if test.value != "" {
+				headers.Set("Retry-After", test.value)
+			}
+			if wait := parseRetryAfter(headers); wait < test.min || wait > test.max {
+				t.Errorf("got %s, want between %s and %s", wait, test.min, test.max)
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"context"
+	"time"
+)
+
+var shutdownGrace = 30 * time.Second
+
+//SetShutdownGrace sets how long requests already in flight may keep running after cancellation
+func SetShutdownGrace(grace time.Duration) {
+	shutdownGrace = grace
+}
+
+//inFlight returns the context for a request that is about to be sent. It is only cancelled
+//once the shutdown grace period has passed after ctx is done, so a cancelled run does not
+//abort requests midway, such as forceReindex submissions.
+func inFlight(ctx context.Context) (context.Context, context.CancelFunc) {
+	reqCtx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-ctx.Done():
+			timer := time.NewTimer(shutdownGrace)
+			defer timer.Stop()
+			select {
+			case <-timer.C:
+				cancel()
+			case <-reqCtx.Done():
+			}
+		case <-reqCtx.Done():
+		}
+	}()
+	return reqCtx, cancel
+}
+
+//sleepContext waits for d, returning false if ctx is cancelled first
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
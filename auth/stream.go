@@ -0,0 +1,135 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+//errUnexpectedJSON is returned when a streamed response does not have the expected shape
+var errUnexpectedJSON = errors.New("unexpected JSON")
+
+//streamArray decodes the elements of the array under field of the JSON object read from body one at a time,
+//calling each with the decoder positioned on the next element. Other fields are skipped
+func streamArray(body io.Reader, field string, each func(*json.Decoder) error) error {
+	decoder := json.NewDecoder(body)
+	if err := expectDelim(decoder, '{'); err != nil {
+		return err
+	}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		if key, _ := token.(string); key != field {
+			var skipped json.RawMessage
+			if err := decoder.Decode(&skipped); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := expectDelim(decoder, '['); err != nil {
+			return err
+		}
+		for decoder.More() {
+			if err := each(decoder); err != nil {
+				return err
+			}
+		}
+		if err := expectDelim(decoder, ']'); err != nil {
+			return err
+		}
+	}
+	return expectDelim(decoder, '}')
+}
+
+func expectDelim(decoder *json.Decoder, delim json.Delim) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if token != delim {
+		return fmt.Errorf("%w: found %v instead of %v", errUnexpectedJSON, token, delim)
+	}
+	return nil
+}
+
+//streamErrorCategory categorizes an error returned while a response body was streamed
+func streamErrorCategory(ctx context.Context, err error) ErrorCategory {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case ctx.Err() != nil || errors.Is(err, context.Canceled):
+		return ErrCancelled
+	case errors.As(err, &syntaxErr) || errors.As(err, &typeErr) || errors.Is(err, errUnexpectedJSON):
+		return ErrDecode
+	}
+	return ErrNetwork
+}
+
+//idleReader bounds each read of a streamed body by timeout, calling abort when the server sends nothing for that
+//long. Time spent between reads, while the caller handles what it read, does not count
+type idleReader struct {
+	body    io.Reader
+	timeout time.Duration
+	abort   func()
+}
+
+func newIdleReader(body io.Reader, timeout time.Duration, abort func()) io.Reader {
+	if timeout <= 0 {
+		return body
+	}
+	return idleReader{body: body, timeout: timeout, abort: abort}
+}
+
+func (r idleReader) Read(p []byte) (int, error) {
+	timer := time.AfterFunc(r.timeout, r.abort)
+	n, err := r.body.Read(p)
+	if !timer.Stop() {
+		return n, fmt.Errorf("no data received for %s", r.timeout)
+	}
+	return n, err
+}
+
+//captureBody passes a response body through to the caller while keeping a copy of what was read. done is called
+//once with the copy, when the body is read to the end, fails or is closed. Only -record and -logHttp use it, the
+//bodies they keep are held until the response has been read
+type captureBody struct {
+	body io.ReadCloser
+	read bytes.Buffer
+	done func(body []byte, err error)
+	once sync.Once
+}
+
+func newCaptureBody(body io.ReadCloser, done func(body []byte, err error)) *captureBody {
+	return &captureBody{body: body, done: done}
+}
+
+func (c *captureBody) Read(p []byte) (int, error) {
+	n, err := c.body.Read(p)
+	c.read.Write(p[:n])
+	if err != nil {
+		c.finish(err)
+	}
+	return n, err
+}
+
+func (c *captureBody) Close() error {
+	err := c.body.Close()
+	c.finish(nil)
+	return err
+}
+
+func (c *captureBody) finish(err error) {
+	c.once.Do(func() {
+		if err == io.EOF {
+			err = nil
+		}
+		c.done(c.read.Bytes(), err)
+	})
+}
@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStreamStalledBodyTimesOutWhileTraced(t *testing.T) {
+	stall := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"files":[{"uri":"/a"},`))
+		w.(http.Flusher).Flush()
+		<-stall
+	}))
+	defer server.Close()
+	defer close(stall)
+
+	dir, err := ioutil.TempDir("", "stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	saved := clientOptions
+	defer func() {
+		httpLog = nil
+		SetClientOptions(saved)
+	}()
+	SetClientOptions(ClientOptions{ReadTimeout: 100 * time.Millisecond, MaxConns: 1})
+	if err := LogHTTPTo(filepath.Join(dir, "http.log")); err != nil {
+		t.Fatal(err)
+	}
+	defer CloseHTTPLog()
+
+	var uris []string
+	done := make(chan error, 1)
+	go func() {
+		_, err := StreamRestAPIContext(context.Background(), ServiceArtifactory, "GET", false, server.URL, "", "", "", nil, NoRetry, func(body io.Reader) error {
+			return streamArray(body, "files", func(decoder *json.Decoder) error {
+				var file struct{ URI string }
+				if err := decoder.Decode(&file); err != nil {
+					return err
+				}
+				uris = append(uris, file.URI)
+				return nil
+			})
+		})
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if Category(err) != ErrNetwork || !strings.Contains(err.Error(), "no data received") {
+			t.Errorf("got %v, want a network error for the stalled read", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stalled body was not timed out")
+	}
+	if len(uris) != 1 || uris[0] != "/a" {
+		t.Errorf("streamed %v before the stall, want [/a]", uris)
+	}
+}
+
+func TestStreamArray(t *testing.T) {
+	tests := []struct {
+		name, body string
+		want       []string
+		wantErr    bool
+	}{
+		{"files", `{"files":[{"uri":"/a"},{"uri":"/b"}]}`, []string{"/a", "/b"}, false},
+		{"other fields skipped", `{"uri":"/repo","created":"x","children":[{"uri":"/c"}],"files":[{"uri":"/a"}],"size":3}`, []string{"/a"}, false},
+		{"empty", `{"files":[]}`, nil, false},
+		{"missing", `{"results":[{"uri":"/a"}]}`, nil, false},
+		{"not an object", `[{"uri":"/a"}]`, nil, true},
+		{"not an array", `{"files":{"uri":"/a"}}`, nil, true},
+		{"truncated", `{"files":[{"uri":"/a"},{"ur`, []string{"/a"}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var uris []string
+			err := streamArray(strings.NewReader(test.body), "files", func(decoder *json.Decoder) error {
+				var file struct{ URI string }
+				if err := decoder.Decode(&file); err != nil {
+					return err
+				}
+				uris = append(uris, file.URI)
+				return nil
+			})
+			if (err != nil) != test.wantErr {
+				t.Errorf("error = %v, want error %t", err, test.wantErr)
+			}
+			if strings.Join(uris, ",") != strings.Join(test.want, ",") {
+				t.Errorf("streamed %v, want %v", uris, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//TLSOptions describes how connections to Artifactory and Xray are secured
+type TLSOptions struct {
+	CACertFile         string
+	ClientCertFile     string
+	ClientKeyFile      string
+	MinVersion         string
+	InsecureSkipVerify bool
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+//SetTLSConfig applies opts to the shared client used by every request
+func SetTLSConfig(opts TLSOptions) error {
+	config, err := opts.config()
+	if err != nil {
+		return err
+	}
+	if opts.InsecureSkipVerify {
+		log.Warn("TLS certificate verification is disabled, only use -insecure against lab instances")
+	}
+	tlsConfig = config
+	httpClient = newClient()
+	return nil
+}
+
+func (opts TLSOptions) config() (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: opts.InsecureSkipVerify}
+
+	if opts.MinVersion != "" {
+		version, ok := tlsVersions[opts.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unknown minimum TLS version %q, use one of 1.0 1.1 1.2 1.3", opts.MinVersion)
+		}
+		config.MinVersion = version
+	}
+
+	if opts.CACertFile != "" {
+		pem, err := ioutil.ReadFile(opts.CACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading CA bundle: %w", err)
+		}
+		//trust the bundle in addition to the system roots
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no PEM certificates found in %s", opts.CACertFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if (opts.ClientCertFile == "") != (opts.ClientKeyFile == "") {
+		return nil, errors.New("client certificate and key must be provided together")
+	}
+	if opts.ClientCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.ClientCertFile, opts.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
@@ -0,0 +1,34 @@
+package auth
+
+import (
+	"strings"
+)
+
+//SetServiceURLs fills in the Artifactory and Xray base URLs. Empty values default to the
+//unified platform layout under c.URL, explicit values may carry their own context path or host
+func (c *Creds) SetServiceURLs(artifactoryURL, xrayURL string) {
+	c.URL = strings.TrimSuffix(c.URL, "/")
+	c.ArtifactoryURL = strings.TrimSuffix(artifactoryURL, "/")
+	if c.ArtifactoryURL == "" {
+		c.ArtifactoryURL = c.URL + "/artifactory"
+	}
+	c.XrayURL = strings.TrimSuffix(xrayURL, "/")
+	if c.XrayURL == "" {
+		c.XrayURL = c.URL + "/xray"
+	}
+}
+
+//ArtifactoryAPI returns the Artifactory URL for path, such as /api/system/ping
+func (c Creds) ArtifactoryAPI(path string) string {
+	return joinURL(c.ArtifactoryURL, path)
+}
+
+//XrayAPI returns the Xray URL for path, such as /api/v1/forceReindex
+func (c Creds) XrayAPI(path string) string {
+	return joinURL(c.XrayURL, path)
+}
+
+//joinURL joins base and path with exactly one slash between them
+func joinURL(base, path string) string {
+	return strings.TrimSuffix(base, "/") + "/" + strings.TrimPrefix(path, "/")
+}
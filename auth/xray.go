@@ -0,0 +1,171 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/lorenyeung/forceReindexXray/helpers"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//Artifact identifies a file to reindex
+type Artifact struct {
+	Repository string `json:"repository"`
+	Path       string `json:"path"`
+}
+
+//ArtifactStatus is the Xray scan status of an artifact, Indexed is only set once it is DONE
+type ArtifactStatus struct {
+	Status  string
+	Indexed bool
+}
+
+//XrayClient is the part of the Xray API the reindex logic uses
+type XrayClient interface {
+	//ForceReindex submits artifacts for indexing, returning the outcome of each artifact in order. Versions taking
+	//one artifact per request report every artifact on its own, the others accept or reject the batch as a whole
+	ForceReindex(ctx context.Context, artifacts []Artifact) []ReindexOutcome
+	//ArtifactStatus returns the scan status of a file in repo
+	ArtifactStatus(ctx context.Context, repo, path string) (ArtifactStatus, error)
+	//Ping checks that Xray is up, with a single attempt
+	Ping(ctx context.Context) error
+	//ReindexAccess checks the user may call the reindex endpoint, without reindexing anything
+	ReindexAccess(ctx context.Context) error
+	//StatusAccess checks the user may call the artifact status endpoint
+	StatusAccess(ctx context.Context) error
+	//Version returns the Xray server version
+	Version(ctx context.Context) (XrayVersion, error)
+}
+
+type xrayClient struct {
+	creds Creds
+	api   xrayAPI
+}
+
+//NewXrayClient returns an XrayClient for creds.XrayURL using the Xray 3.0 endpoints
+func NewXrayClient(creds Creds) XrayClient {
+	return NewXrayClientForVersion(creds, XrayVersion{Major: 3})
+}
+
+//NewXrayClientForVersion returns an XrayClient using the endpoints and payloads of Xray version
+func NewXrayClientForVersion(creds Creds, version XrayVersion) XrayClient {
+	return xrayClient{creds: creds, api: apiFor(version)}
+}
+
+var jsonHeader = map[string]string{
+	"Content-Type": "application/json",
+}
+
+func (c xrayClient) post(ctx context.Context, path string, body interface{}) ([]byte, string, error) {
+	url := c.creds.XrayAPI(path)
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, url, newRequestError(ErrRequest, "POST", url, 0, err)
+	}
+	//calls wait while the circuit breaker is open, and fail once it has tripped
+	if err := xrayBreaker.allow(ctx, c.Ping); err != nil {
+		return nil, url, err
+	}
+	data, _, _, err := GetRestAPIContext(ctx, ServiceXray, "POST", true, url, c.creds.Username, c.creds.Apikey, string(payload), jsonHeader, 0)
+	xrayBreaker.record(err)
+	return data, url, err
+}
+
+func (c xrayClient) Ping(ctx context.Context) error {
+	url := c.creds.XrayAPI("/api/v1/system/ping")
+	_, _, _, err := GetRestAPIContext(ctx, ServiceXray, "GET", true, url, c.creds.Username, c.creds.Apikey, "", nil, NoRetry)
+	return err
+}
+
+func (c xrayClient) ForceReindex(ctx context.Context, artifacts []Artifact) []ReindexOutcome {
+	var results []ReindexOutcome
+	requests := c.api.reindexRequests(artifacts)
+	for i, request := range requests {
+		data, _, err := c.post(ctx, c.api.reindexPath, request.payload)
+		if err != nil {
+			log.Warn("Unexpected Xray response for ", len(request.artifacts), " artifacts: ", err, " ", helpers.TruncateBody(data))
+		} else {
+			log.Info("Xray accepted ", len(request.artifacts), " artifacts: ", helpers.TruncateBody(data))
+		}
+		results = append(results, outcomes(request.artifacts, err)...)
+		switch Category(err) {
+		case ErrAuth, ErrCancelled, ErrCircuitOpen:
+			//the remaining requests would fail the same way, they are not sent
+			for _, rest := range requests[i+1:] {
+				results = append(results, outcomes(rest.artifacts, err)...)
+			}
+			return results
+		}
+	}
+	return results
+}
+
+func (c xrayClient) ArtifactStatus(ctx context.Context, repo, path string) (ArtifactStatus, error) {
+	var status ArtifactStatus
+	data, url, err := c.post(ctx, c.api.statusPath, map[string]string{"repo": repo, "path": strings.TrimPrefix(path, "/")})
+	if err != nil {
+		return status, err
+	}
+	var response struct {
+		Overall struct {
+			Status string `json:"status"`
+		} `json:"overall"`
+	}
+	if err := DecodeJSON(data, &response, url); err != nil {
+		return status, err
+	}
+	status.Status = response.Overall.Status
+	status.Indexed = status.Status == "DONE"
+	return status, nil
+}
+
+func (c xrayClient) ReindexAccess(ctx context.Context) error {
+	return c.probe(ctx, c.api.reindexPath, c.api.reindexProbe, http.StatusBadRequest, http.StatusUnprocessableEntity)
+}
+
+func (c xrayClient) StatusAccess(ctx context.Context) error {
+	return c.probe(ctx, c.api.statusPath, map[string]string{"repo": "", "path": ""}, http.StatusBadRequest, http.StatusNotFound, http.StatusUnprocessableEntity)
+}
+
+//probe sends an invalid request to path, a single attempt that bypasses the circuit breaker. Responses with one of
+//the rejected status codes show the user got past the permission check
+func (c xrayClient) probe(ctx context.Context, path string, body interface{}, rejected ...int) error {
+	url := c.creds.XrayAPI(path)
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return newRequestError(ErrRequest, "POST", url, 0, err)
+	}
+	_, _, _, err = GetRestAPIContext(ctx, ServiceXray, "POST", true, url, c.creds.Username, c.creds.Apikey, string(payload), jsonHeader, NoRetry)
+	var reqErr *RequestError
+	if errors.As(err, &reqErr) {
+		for _, code := range rejected {
+			if reqErr.StatusCode == code {
+				return nil
+			}
+		}
+	}
+	return err
+}
+
+func (c xrayClient) Version(ctx context.Context) (XrayVersion, error) {
+	url := c.creds.XrayAPI("/api/v1/system/version")
+	data, _, _, err := GetRestAPIContext(ctx, ServiceXray, "GET", true, url, c.creds.Username, c.creds.Apikey, "", nil, 0)
+	if err != nil {
+		return XrayVersion{}, err
+	}
+	var response struct {
+		Version string `json:"xray_version"`
+	}
+	if err := DecodeJSON(data, &response, url); err != nil {
+		return XrayVersion{}, err
+	}
+	version, err := ParseXrayVersion(response.Version)
+	if err != nil {
+		return version, newRequestError(ErrDecode, "GET", url, 0, err)
+	}
+	return version, nil
+}
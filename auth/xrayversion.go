@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+//XrayVersion is a parsed Xray version such as 3.51.3
+type XrayVersion struct {
+	Major, Minor, Patch int
+}
+
+//ParseXrayVersion parses versions as reported by Xray, a leading v and any suffix after the patch level are ignored
+func ParseXrayVersion(version string) (XrayVersion, error) {
+	var v XrayVersion
+	trimmed := strings.TrimPrefix(strings.TrimSpace(version), "v")
+	if i := strings.IndexAny(trimmed, "-+ "); i >= 0 {
+		trimmed = trimmed[:i]
+	}
+	parts := strings.Split(trimmed, ".")
+	if len(parts) > 3 {
+		return v, fmt.Errorf("invalid Xray version %q", version)
+	}
+	numbers := []*int{&v.Major, &v.Minor, &v.Patch}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return v, fmt.Errorf("invalid Xray version %q", version)
+		}
+		*numbers[i] = n
+	}
+	return v, nil
+}
+
+func (v XrayVersion) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+//AtLeast reports whether v is major.minor or later
+func (v XrayVersion) AtLeast(major, minor int) bool {
+	return v.Major > major || (v.Major == major && v.Minor >= minor)
+}
+
+//xrayAPI is the set of endpoints and payload shapes of an Xray version range
+type xrayAPI struct {
+	name string
+	//from is the first version the endpoints are used for
+	fromMajor, fromMinor int
+	reindexPath          string
+	statusPath           string
+	//reindexRequests splits artifacts into the requests submitting them
+	reindexRequests func(artifacts []Artifact) []reindexRequest
+	//reindexProbe is an empty submission, rejected once the permission check has passed
+	reindexProbe interface{}
+}
+
+//reindexRequest is the body of one reindex request and the artifacts it submits
+type reindexRequest struct {
+	artifacts []Artifact
+	payload   interface{}
+}
+
+//xrayAPIs is ordered newest first
+var xrayAPIs = []xrayAPI{
+	{
+		//Scan Now replaced forceReindex and takes a single repo path per request
+		name:        "index v2",
+		fromMajor:   3,
+		fromMinor:   76,
+		reindexPath: "/api/v2/index",
+		statusPath:  "/api/v1/artifact/status",
+		reindexRequests: func(artifacts []Artifact) []reindexRequest {
+			var requests []reindexRequest
+			for i, artifact := range artifacts {
+				requests = append(requests, reindexRequest{
+					artifacts: artifacts[i : i+1],
+					payload:   map[string]string{"repo_path": artifact.Repository + "/" + strings.TrimPrefix(artifact.Path, "/")},
+				})
+			}
+			return requests
+		},
+		reindexProbe: map[string]string{"repo_path": ""},
+	},
+	{
+		name:        "forceReindex v1",
+		fromMajor:   3,
+		fromMinor:   0,
+		reindexPath: "/api/v1/forceReindex",
+		statusPath:  "/api/v1/artifact/status",
+		reindexRequests: func(artifacts []Artifact) []reindexRequest {
+			return []reindexRequest{{artifacts: artifacts, payload: map[string][]Artifact{"artifacts": artifacts}}}
+		},
+		reindexProbe: map[string][]Artifact{"artifacts": {}},
+	},
+}
+
+//apiFor returns the endpoints to use with v
+func apiFor(v XrayVersion) xrayAPI {
+	for _, api := range xrayAPIs {
+		if v.AtLeast(api.fromMajor, api.fromMinor) {
+			return api
+		}
+	}
+	return xrayAPIs[len(xrayAPIs)-1]
+}
+
+//Xray versions the tool was tested against, newer versions are used with the latest known endpoints
+const (
+	minXrayMajor   = 3
+	maxTestedMajor = 3
+	maxTestedMinor = 80
+)
+
+//CheckXrayVersion returns an error for versions without the endpoints used, and a warning for versions newer than
+//the ones tested
+func CheckXrayVersion(v XrayVersion) (string, error) {
+	if v.Major < minXrayMajor {
+		return "", fmt.Errorf("Xray %s is not supported, %d.x or later is required", v, minXrayMajor)
+	}
+	if v.AtLeast(maxTestedMajor, maxTestedMinor+1) {
+		return fmt.Sprintf("Xray %s is untested, newest tested is %d.%d. Using %s endpoints", v, maxTestedMajor, maxTestedMinor, apiFor(v).name), nil
+	}
+	return "", nil
+}
@@ -0,0 +1,49 @@
+package auth
+
+import "testing"
+
+func TestParseXrayVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		want    XrayVersion
+		wantErr bool
+	}{
+		{"3.51.3", XrayVersion{3, 51, 3}, false},
+		{"v3.76.0", XrayVersion{3, 76, 0}, false},
+		{" 3.60 ", XrayVersion{3, 60, 0}, false},
+		{"3", XrayVersion{3, 0, 0}, false},
+		{"3.80.1-rc1", XrayVersion{3, 80, 1}, false},
+		{"3.80.1+build.7", XrayVersion{3, 80, 1}, false},
+		{"3.51.3.1", XrayVersion{}, true},
+		{"3.x", XrayVersion{}, true},
+		{"", XrayVersion{}, true},
+		{"-1.2", XrayVersion{}, true},
+	}
+	for _, test := range tests {
+		got, err := ParseXrayVersion(test.version)
+		if (err != nil) != test.wantErr {
+			t.Errorf("ParseXrayVersion(%q) error = %v, want error %t", test.version, err, test.wantErr)
+			continue
+		}
+		if !test.wantErr && got != test.want {
+			t.Errorf("ParseXrayVersion(%q) = %s, want %s", test.version, got, test.want)
+		}
+	}
+}
+
+func TestAPIFor(t *testing.T) {
+	tests := []struct {
+		version XrayVersion
+		want    string
+	}{
+		{XrayVersion{3, 51, 3}, "/api/v1/forceReindex"},
+		{XrayVersion{3, 75, 9}, "/api/v1/forceReindex"},
+		{XrayVersion{3, 76, 0}, "/api/v2/index"},
+		{XrayVersion{4, 1, 0}, "/api/v2/index"},
+	}
+	for _, test := range tests {
+		if got := apiFor(test.version).reindexPath; got != test.want {
+			t.Errorf("apiFor(%s) reindexes with %s, want %s", test.version, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/lorenyeung/forceReindexXray/mockserver"
+)
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:0", "Address to listen on")
+	typesFile := flag.String("typesFile", "", "Write a supported_types.json matching the seeded repos to this file")
+	xrayVersion := flag.String("xrayVersion", mockserver.DefaultXrayVersion, "Version reported by Xray, can be changed with a POST to /mock/xrayVersion")
+	flag.Parse()
+
+	if *typesFile != "" {
+		if err := ioutil.WriteFile(*typesFile, []byte(mockserver.SupportedTypes), 0644); err != nil {
+			fmt.Fprintln(os.Stderr, "Could not write types file:", err)
+			os.Exit(1)
+		}
+	}
+	listener, err := net.Listen("tcp", *addr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Could not listen:", err)
+		os.Exit(1)
+	}
+	server := mockserver.NewUnstarted(mockserver.DefaultRepos())
+	server.SetXrayVersion(*xrayVersion)
+	server.Listener.Close()
+	server.Listener = listener
+	server.Start()
+	defer server.Close()
+	fmt.Println(server.URL)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	<-signals
+}
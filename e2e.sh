@@ -0,0 +1,152 @@
+#!/bin/bash
+# End to end tests: runs each mode of the tool against the mock Artifactory/Xray server (see mockserver)
+set -u
+WORKDIR=$(mktemp -d)
+MOCK_PID=""
+trap '[ -n "$MOCK_PID" ] && kill $MOCK_PID 2>/dev/null; rm -rf "$WORKDIR"' EXIT
+
+go build -o "$WORKDIR/reindex" . || exit 1
+go build -o "$WORKDIR/mockserver" ./cmd/mockserver || exit 1
+"$WORKDIR/mockserver" -typesFile "$WORKDIR/supported_types.json" > "$WORKDIR/url" &
+MOCK_PID=$!
+for i in $(seq 50); do
+    [ -s "$WORKDIR/url" ] && break
+    sleep 0.1
+done
+URL=$(head -1 "$WORKDIR/url")
+if [ -z "$URL" ]; then
+    echo "mock server did not start"
+    exit 1
+fi
+
+FAILED=0
+# expect <name> <pattern> <reindex args...>, passes when the output contains pattern. Each run starts without a
+# state file unless STATE names one to keep between runs
+expect() {
+    local name=$1 pattern=$2
+    shift 2
+    local state=${STATE:-$WORKDIR/run.state.json}
+    [ -z "${STATE:-}" ] && rm -f "$state"
+    local output
+    output=$("$WORKDIR/reindex" -url "$URL" -typesFile "$WORKDIR/supported_types.json" -retries 0 -stateFile "$state" "$@" 2>&1)
+    if echo "$output" | grep -q -- "$pattern"; then
+        echo "PASS $name"
+    else
+        echo "FAIL $name: expected \"$pattern\""
+        echo "$output" | sed 's/^/    /'
+        FAILED=1
+    fi
+}
+
+BASIC="-user admin -apikey password"
+expect "repo npm" "Total indexed count:2/2 Total not indexable:1" $BASIC -repo npm-local
+expect "repo maven" "Total indexed count:3/3 Total not indexable:3 Files with no extension:1" $BASIC -repo maven-local
+expect "repo remote" "Total indexed count:1/1" $BASIC -repo jcenter-cache
+expect "repo missing" "Repo not found in indexed list" $BASIC -repo missing-local
+expect "list" "Indexing specified list of repos:npm-local,docker-local" $BASIC -list npm-local,docker-local
+expect "list missing" "missing-local was not found in the indexed list" $BASIC -list npm-local,missing-local
+expect "all docker" "Total indexed count:1/1 Total not indexable:2" $BASIC -all
+expect "all maven" "Total indexed count:3/3" $BASIC -all
+expect "indexed unindexed" "FAILED" $BASIC -repo maven-local -indexed unindexed
+expect "indexed all" "DONE" $BASIC -repo npm-local -indexed all
+mkdir -p "$WORKDIR/jfrog" && echo "not json" > "$WORKDIR/jfrog/jfrog-cli.conf.v6"
+JFROG_CLI_HOME_DIR="$WORKDIR/jfrog" expect "broken cli config unused" "Total indexed count:2/2" $BASIC -repo npm-local
+JFROG_CLI_HOME_DIR="$WORKDIR/jfrog" expect "broken cli config" "reading JFrog CLI config" $BASIC -repo npm-local -serverId dev
+expect "token" "Total indexed count:2/2" -token token -repo npm-local
+expect "bad credentials" "Please verify your URL and/or credentials" -user admin -apikey wrong -repo npm-local
+expect "no repos" "No repos were specified" $BASIC
+expect "batch split" "Total indexed count:3/4" $BASIC -repo npm-legacy
+expect "reindex workers" "Total indexed count:3/4" $BASIC -repo npm-legacy -batchSize 1 -reindexWorkers 3
+STATE="$WORKDIR/state.json"
+expect "resume saved" "rerun with -resume to continue and retry 1 failed files" $BASIC -repo npm-legacy -batchSize 1
+expect "resume" "Resumed npm-legacy, skipped 3 files" $BASIC -repo npm-legacy -batchSize 1 -resume
+expect "resume other selection" "was written for" $BASIC -repo npm-local -resume
+expect "state kept" "holds an unfinished run" $BASIC -repo npm-legacy -batchSize 1
+expect "state kept other selection" "holds an unfinished run" $BASIC -repo npm-local
+expect "restart and resume" "-resume and -restart cannot be used together" $BASIC -repo npm-legacy -resume -restart
+expect "resume saved again" "rerun with -resume" $BASIC -repo npm-legacy -batchSize 1 -restart
+expect "resume saved relist" "rerun with -resume" $BASIC -repo npm-legacy -batchSize 1 -restart
+sed -i 's|"lastSubmitted": "[^"]*"|"lastSubmitted": "/deleted/-/deleted-1.0.0.tgz"|' "$STATE"
+expect "resume relist" "listing it again to resubmit it all" $BASIC -repo npm-legacy -batchSize 1 -resume
+expect "resume saved deleted" "rerun with -resume" $BASIC -repo npm-legacy -batchSize 1 -restart
+sed -i 's|"/c/-/c-0.0.1.tgz": |"/deleted/-/deleted-1.0.0.tgz": |' "$STATE"
+expect "resume deleted failure" "Dropped 1 failed files of npm-legacy that are no longer listed" $BASIC -repo npm-legacy -batchSize 1 -resume
+expect "resume deleted failure completes" "no state file" $BASIC -repo npm-legacy -batchSize 1 -resume
+expect "resume window saved" "rerun with -resume" $BASIC -repo npm-legacy -batchSize 1 -modifiedAfter 100000h
+expect "resume window" "Resumed npm-legacy, skipped 3 files" $BASIC -repo npm-legacy -batchSize 1 -modifiedAfter 100000h -resume
+expect "resume other discovery" "was written for" $BASIC -repo npm-legacy -batchSize 1 -discovery aql -resume
+unset STATE
+expect "batch outcome" "Not submitted: npm-legacy/c/-/c-0.0.1.tgz" $BASIC -repo npm-legacy -batchSize 2
+expect "xray version" "Xray version:3.51.3" $BASIC -repo npm-local
+expect "xray version override" "Xray version:3.60.0" $BASIC -repo npm-local -xrayVersion 3.60
+curl -s -X POST -d 4.1.0 "$URL/mock/xrayVersion"
+expect "xray untested" "Xray 4.1.0 is untested" $BASIC -repo npm-local
+expect "xray v2 index" "Total indexed count:2/2" $BASIC -repo npm-local
+curl -s -X POST -d 2.11.4 "$URL/mock/xrayVersion"
+expect "xray unsupported" "Xray 2.11.4 is not supported" $BASIC -repo npm-local
+curl -s -X POST -d 3.51.3 "$URL/mock/xrayVersion"
+expect "preflight" "Preflight passed" $BASIC -preflight
+expect "preflight permission" "FAIL Xray reindex permission" -user reader -apikey password -preflight
+curl -s -X POST -d "0 -1" "$URL/mock/xrayFailures"
+expect "preflight xray down" "FAIL Xray ping" $BASIC -preflight
+curl -s -X POST -d "0 0" "$URL/mock/xrayFailures"
+expect "preflight before work" "Preflight failed, no work was started" -user reader -apikey password -repo npm-local
+expect "preflight indexed" "PASS Xray reindex permission skipped, -indexed run" -user reader -apikey password -repo npm-local -indexed all
+# the preflight makes 3 Xray requests before any submission
+BREAKER="-xrayFailureThreshold 2 -xrayProbeInterval 100ms -xrayMaxOutage 1s -batchSize 1"
+curl -s -X POST -d "3 4" "$URL/mock/xrayFailures"
+expect "xray probe interval" "-xrayProbeInterval must be more than 0" $BASIC -repo npm-local -xrayProbeInterval 0
+expect "xray recovers" "Xray recovered" $BASIC $BREAKER -repo maven-local
+curl -s -X POST -d "3 4" "$URL/mock/xrayFailures"
+expect "xray recovered count" "Total indexed count:1/3" $BASIC $BREAKER -repo maven-local
+curl -s -X POST -d "3 -1" "$URL/mock/xrayFailures"
+expect "xray outage" "Run aborted" $BASIC $BREAKER -all
+curl -s -X POST -d "0 0" "$URL/mock/xrayFailures"
+expect "aql discovery" "Total indexed count:3/3 Total not indexable:0" $BASIC -repo maven-local -discovery aql
+expect "aql paging" "Total indexed count:3/3" $BASIC -repo maven-local -discovery aql -aqlPageSize 2
+expect "aql folder" "Total indexed count:2/2" $BASIC -repo maven-local -folder /com/acme/app -discovery aql
+expect "aql remote" "Total indexed count:1/1" $BASIC -repo jcenter -discovery aql
+curl -s -X POST -d off "$URL/mock/aql"
+expect "aql fallback" "falling back to the storage list" $BASIC -repo maven-local -discovery aql
+expect "aql fallback count" "Total indexed count:3/3 Total not indexable:3" $BASIC -repo maven-local -discovery aql
+curl -s -X POST -d on "$URL/mock/aql"
+expect "modified window" "Total indexed count:2/2" $BASIC -repo maven-local -modifiedBefore 2021-07-05
+expect "modified window outside" "Files outside the time window:3" $BASIC -repo maven-local -modifiedBefore 2021-07-05
+expect "modified duration" "Total indexed count:0/0" $BASIC -repo maven-local -modifiedAfter 72h
+expect "created window" "Total indexed count:1/1" $BASIC -repo maven-local -discovery aql -createdAfter 2021-07-05T00:00:00Z -createdBefore 2021-07-09
+expect "created needs aql" "need -discovery aql" $BASIC -repo maven-local -createdAfter 72h
+expect "window invalid" "is neither a timestamp" $BASIC -repo maven-local -modifiedAfter yesterday
+expect "include" "Files excluded by -include and -exclude:2" $BASIC -repo maven-local -include "**/app/**"
+expect "include count" "Total indexed count:2/2 Total not indexable:2" $BASIC -repo maven-local -include "**/app/**"
+expect "exclude" "Total indexed count:2/2" $BASIC -repo maven-local -exclude "**/*-sources.jar"
+expect "exclude repeated" "Total indexed count:1/1 Total not indexable:0" $BASIC -repo npm-local -exclude ".npm/**" -exclude "lodash/**"
+BEFORE=$(curl -s "$URL/mock/reindexed" | grep -o '"path"' | wc -l)
+expect "dry run" "Would reindex: npm-local/lodash/-/lodash-4.17.21.tgz" $BASIC -repo npm-local -dryRun
+expect "dry run totals" "Total indexed count:3/3 Total not indexable:3" $BASIC -repo maven-local -dryRun
+expect "dry run file" "2 artifacts would have been reindexed, written to" $BASIC -repo npm-local -dryRun -dryRunFile "$WORKDIR/plan.jsonl"
+if [ "$(curl -s "$URL/mock/reindexed" | grep -o '"path"' | wc -l)" != "$BEFORE" ] || ! grep -q '"repository":"npm-local","path":"/left-pad/-/left-pad-1.3.0.tgz"' "$WORKDIR/plan.jsonl"; then
+    echo "FAIL dry run submitted nothing"
+    FAILED=1
+else
+    echo "PASS dry run submitted nothing"
+fi
+expect "log http" "Tracing HTTP requests" $BASIC -repo npm-local -logHttp "$WORKDIR/http.log"
+if grep -q "password" "$WORKDIR/http.log" || ! grep -q "Authorization: REDACTED" "$WORKDIR/http.log"; then
+    echo "FAIL log http redaction: credentials found in $WORKDIR/http.log"
+    FAILED=1
+else
+    echo "PASS log http redaction"
+fi
+expect "record" "Recording requests to" $BASIC -repo npm-local -record "$WORKDIR/npm.cassette"
+expect "replay" "Total indexed count:2/2" -repo npm-local -replay "$WORKDIR/npm.cassette"
+if grep -q "password" "$WORKDIR/npm.cassette"; then
+    echo "FAIL record redaction: credentials found in $WORKDIR/npm.cassette"
+    FAILED=1
+else
+    echo "PASS record redaction"
+fi
+expect "record replay" "cannot be combined" -repo npm-local -replay "$WORKDIR/npm.cassette" -record "$WORKDIR/again.cassette"
+
+REINDEXED=$(curl -s "$URL/mock/reindexed")
+echo "Artifacts submitted to forceReindex: $(echo "$REINDEXED" | grep -o '"path"' | wc -l)"
+exit $FAILED
@@ -0,0 +1,239 @@
+package helpers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+//DefaultStateFile is where -all, -list and -repo runs record their progress for -resume
+const DefaultStateFile = "forceReindexXray.state.json"
+
+//Checkpoint is the progress of a reindex run, saved after every batch. A nil *Checkpoint records nothing
+type Checkpoint struct {
+	Key            string                   `json:"key"`
+	URL            string                   `json:"url"`
+	Selection      string                   `json:"selection"`
+	Window         TimeWindow               `json:"window"`
+	CompletedRepos []string                 `json:"completedRepos"`
+	Repos          map[string]*RepoProgress `json:"repos"`
+
+	path string
+	mu   sync.Mutex
+}
+
+//RepoProgress is how far a repo got. Files up to LastSubmitted were submitted, apart from the Failed ones,
+//which map the path to the error
+type RepoProgress struct {
+	LastSubmitted string            `json:"lastSubmitted"`
+	Failed        map[string]string `json:"failed,omitempty"`
+}
+
+//CheckpointKey identifies a run by the instance and what was selected to reindex
+func CheckpointKey(url, selection string) string {
+	sum := sha256.Sum256([]byte(url + "\n" + selection))
+	return hex.EncodeToString(sum[:])
+}
+
+//OpenCheckpoint starts recording progress to path. With resume, the progress already in path is continued, as long
+//as it was written for the same URL and selection, and so is its time window: durations in the window flags resolve
+//to other times on every run, window is only saved by the first one. Without it, path is only overwritten when it
+//holds no progress or restart is given, so an unfinished run is not lost by forgetting -resume
+func OpenCheckpoint(path, url, selection string, window TimeWindow, resume, restart bool) (*Checkpoint, error) {
+	checkpoint := &Checkpoint{
+		Key:       CheckpointKey(url, selection),
+		URL:       url,
+		Selection: selection,
+		Window:    window,
+		Repos:     make(map[string]*RepoProgress),
+		path:      path,
+	}
+	data, err := ioutil.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+		if resume {
+			return checkpoint, fmt.Errorf("-resume given but there is no state file at %s", path)
+		}
+		return checkpoint, checkpoint.Save()
+	case err != nil:
+		return checkpoint, err
+	case !resume && restart:
+		return checkpoint, checkpoint.Save()
+	}
+
+	var saved Checkpoint
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return checkpoint, fmt.Errorf("reading state file %s: %w", path, err)
+	}
+	if !resume {
+		if len(saved.CompletedRepos) > 0 || len(saved.Repos) > 0 {
+			return checkpoint, fmt.Errorf("state file %s holds an unfinished run of %s with %s, continue it with -resume or discard it with -restart", path, saved.URL, saved.Selection)
+		}
+		return checkpoint, checkpoint.Save()
+	}
+	if saved.Key != checkpoint.Key {
+		return checkpoint, fmt.Errorf("state file %s was written for %s with %s, not %s with %s", path, saved.URL, saved.Selection, url, selection)
+	}
+	checkpoint.Window = saved.Window
+	checkpoint.CompletedRepos = saved.CompletedRepos
+	if saved.Repos != nil {
+		checkpoint.Repos = saved.Repos
+	}
+	return checkpoint, nil
+}
+
+//RepoCompleted reports whether every file of repo was submitted in an earlier run
+func (c *Checkpoint) RepoCompleted(repo string) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, completed := range c.CompletedRepos {
+		if completed == repo {
+			return true
+		}
+	}
+	return false
+}
+
+//ResumePoint returns the last file of repo submitted in an earlier run and the files that failed up to it
+func (c *Checkpoint) ResumePoint(repo string) (string, map[string]bool) {
+	failed := make(map[string]bool)
+	if c == nil {
+		return "", failed
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	progress, ok := c.Repos[repo]
+	if !ok {
+		return "", failed
+	}
+	for path := range progress.Failed {
+		failed[path] = true
+	}
+	return progress.LastSubmitted, failed
+}
+
+//Submitted records that every file of repo up to lastPath was submitted
+func (c *Checkpoint) Submitted(repo, lastPath string) error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	c.progress(repo).LastSubmitted = lastPath
+	c.mu.Unlock()
+	return c.Save()
+}
+
+//Outcome records whether path was accepted, failures are retried by -resume
+func (c *Checkpoint) Outcome(repo, path string, err error) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	progress := c.progress(repo)
+	if err == nil {
+		delete(progress.Failed, path)
+		return
+	}
+	if progress.Failed == nil {
+		progress.Failed = make(map[string]string)
+	}
+	progress.Failed[path] = err.Error()
+}
+
+//DropFailed forgets failed files of repo that were deleted since, they would otherwise keep it from completing
+func (c *Checkpoint) DropFailed(repo string, paths []string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if progress, ok := c.Repos[repo]; ok {
+		for _, path := range paths {
+			delete(progress.Failed, path)
+		}
+	}
+}
+
+//CompleteRepo records that every file of repo was submitted
+func (c *Checkpoint) CompleteRepo(repo string) error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	if progress, ok := c.Repos[repo]; !ok || len(progress.Failed) == 0 {
+		delete(c.Repos, repo)
+		c.CompletedRepos = append(c.CompletedRepos, repo)
+		sort.Strings(c.CompletedRepos)
+	}
+	c.mu.Unlock()
+	return c.Save()
+}
+
+//FailedCount is the number of files whose submission failed and is retried by -resume
+func (c *Checkpoint) FailedCount() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var count int
+	for _, progress := range c.Repos {
+		count += len(progress.Failed)
+	}
+	return count
+}
+
+//Save writes the checkpoint. The file is replaced in one step so a crash never leaves it half written
+func (c *Checkpoint) Save() error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	tmp, err := ioutil.TempFile(filepath.Dir(c.path), filepath.Base(c.path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), c.path)
+}
+
+//Remove deletes the state file once nothing is left to resume
+func (c *Checkpoint) Remove() error {
+	if c == nil {
+		return nil
+	}
+	return os.Remove(c.path)
+}
+
+func (c *Checkpoint) progress(repo string) *RepoProgress {
+	progress, ok := c.Repos[repo]
+	if !ok {
+		progress = &RepoProgress{}
+		c.Repos[repo] = progress
+	}
+	return progress
+}
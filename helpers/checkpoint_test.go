@@ -0,0 +1,144 @@
+package helpers
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func tempStateFile(t *testing.T) (string, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "state.json"), func() { os.RemoveAll(dir) }
+}
+
+func TestCheckpointResume(t *testing.T) {
+	path, cleanup := tempStateFile(t)
+	defer cleanup()
+
+	checkpoint, err := OpenCheckpoint(path, "http://arti", "repo=npm-local", TimeWindow{}, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkpoint.Outcome("npm-local", "/a.tgz", nil)
+	checkpoint.Outcome("npm-local", "/b.tgz", errors.New("rejected"))
+	if err := checkpoint.Submitted("npm-local", "/b.tgz"); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkpoint.CompleteRepo("maven-local"); err != nil {
+		t.Fatal(err)
+	}
+	//a repo with failures is not completed
+	if err := checkpoint.CompleteRepo("npm-local"); err != nil {
+		t.Fatal(err)
+	}
+
+	resumed, err := OpenCheckpoint(path, "http://arti", "repo=npm-local", TimeWindow{}, true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resumed.RepoCompleted("maven-local") || resumed.RepoCompleted("npm-local") {
+		t.Errorf("completed repos %v, want only maven-local", resumed.CompletedRepos)
+	}
+	last, failed := resumed.ResumePoint("npm-local")
+	if last != "/b.tgz" || !reflect.DeepEqual(failed, map[string]bool{"/b.tgz": true}) {
+		t.Errorf("resume point %s %v, want /b.tgz with /b.tgz failed", last, failed)
+	}
+	if resumed.FailedCount() != 1 {
+		t.Errorf("FailedCount = %d, want 1", resumed.FailedCount())
+	}
+
+	//once the failure is dropped the repo completes
+	resumed.DropFailed("npm-local", []string{"/b.tgz"})
+	if err := resumed.CompleteRepo("npm-local"); err != nil {
+		t.Fatal(err)
+	}
+	if !resumed.RepoCompleted("npm-local") || resumed.FailedCount() != 0 {
+		t.Errorf("npm-local not completed after dropping its failure: %+v", resumed.Repos)
+	}
+	if err := resumed.Remove(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("state file still exists: %v", err)
+	}
+}
+
+func TestOpenCheckpoint(t *testing.T) {
+	tests := []struct {
+		name            string
+		saved           bool
+		url, selection  string
+		resume, restart bool
+		wantErr         string
+	}{
+		{"new", false, "http://arti", "repo=npm-local", false, false, ""},
+		{"resume without state", false, "http://arti", "repo=npm-local", true, false, "no state file"},
+		{"resume", true, "http://arti", "repo=npm-local", true, false, ""},
+		{"resume other selection", true, "http://arti", "repo=maven-local", true, false, "was written for"},
+		{"resume other url", true, "http://other", "repo=npm-local", true, false, "was written for"},
+		{"unfinished run kept", true, "http://arti", "repo=npm-local", false, false, "holds an unfinished run"},
+		{"unfinished run of other selection kept", true, "http://arti", "repo=maven-local", false, false, "holds an unfinished run"},
+		{"restart", true, "http://arti", "repo=maven-local", false, true, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path, cleanup := tempStateFile(t)
+			defer cleanup()
+			if test.saved {
+				saved, err := OpenCheckpoint(path, "http://arti", "repo=npm-local", TimeWindow{}, false, false)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := saved.Submitted("npm-local", "/a.tgz"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			checkpoint, err := OpenCheckpoint(path, test.url, test.selection, TimeWindow{}, test.resume, test.restart)
+			if test.wantErr == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("error = %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			last, _ := checkpoint.ResumePoint("npm-local")
+			if want := map[bool]string{true: "/a.tgz", false: ""}[test.resume]; last != want {
+				t.Errorf("resume point %q, want %q", last, want)
+			}
+		})
+	}
+}
+
+func TestCheckpointEmptyStateOverwritten(t *testing.T) {
+	path, cleanup := tempStateFile(t)
+	defer cleanup()
+	//a run that saved no progress yet is not worth keeping
+	if _, err := OpenCheckpoint(path, "http://arti", "repo=npm-local", TimeWindow{}, false, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenCheckpoint(path, "http://arti", "repo=maven-local", TimeWindow{}, false, false); err != nil {
+		t.Errorf("state file without progress was not overwritten: %v", err)
+	}
+}
+
+func TestNilCheckpoint(t *testing.T) {
+	var checkpoint *Checkpoint
+	checkpoint.Outcome("npm-local", "/a.tgz", errors.New("rejected"))
+	checkpoint.DropFailed("npm-local", []string{"/a.tgz"})
+	if err := checkpoint.Submitted("npm-local", "/a.tgz"); err != nil {
+		t.Error(err)
+	}
+	if checkpoint.RepoCompleted("npm-local") || checkpoint.FailedCount() != 0 {
+		t.Error("nil checkpoint recorded progress")
+	}
+}
@@ -0,0 +1,263 @@
+package helpers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+//DefaultConfigFile is read when -config is not given, if it exists
+const DefaultConfigFile = ".forceReindexXray.json"
+
+//configFile is the -config layout. Settings are keyed by flag name, e.g. "reportWorkers", and apply to every
+//profile. A profile's settings override them, and flags given on the command line override both
+type configFile struct {
+	DefaultProfile string
+	Profiles       map[string]map[string]interface{}
+	Settings       map[string]interface{}
+}
+
+//settings that only make sense on the command line
+var commandLineOnly = map[string]bool{"config": true, "profile": true, "v": true}
+
+//applyConfig sets every flag not given on the command line from the config file and selected profile
+func applyConfig(path, profile string) error {
+	explicit := path != ""
+	if !explicit {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil
+		}
+		path = filepath.Join(home, DefaultConfigFile)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if !explicit && os.IsNotExist(err) {
+			if profile != "" {
+				return fmt.Errorf("-profile %s given but no config file found at %s, use -config", profile, path)
+			}
+			return nil
+		}
+		return err
+	}
+
+	config, err := parseConfig(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	settings := config.Settings
+	if profile == "" {
+		profile = config.DefaultProfile
+	}
+	if profile != "" {
+		values, ok := config.Profiles[profile]
+		if !ok {
+			var names []string
+			for name := range config.Profiles {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return fmt.Errorf("%s: profile %s not found, available profiles: %s", path, profile, strings.Join(names, ","))
+		}
+		for name, value := range values {
+			settings[name] = value
+		}
+	}
+
+	given := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+	var problems []string
+	for _, name := range sortedKeys(settings) {
+		value := settings[name]
+		if name == "repos" {
+			name = "list"
+		}
+		if commandLineOnly[name] || flag.Lookup(name) == nil {
+			problems = append(problems, "unknown setting "+name)
+			continue
+		}
+		if given[name] {
+			continue
+		}
+		//repeatable flags are set once per item of a list
+		if items, isList := value.([]interface{}); isList {
+			if _, repeatable := flag.Lookup(name).Value.(*Patterns); repeatable {
+				for _, item := range items {
+					str, err := settingString(item)
+					if err == nil {
+						err = flag.Set(name, str)
+					}
+					if err != nil {
+						problems = append(problems, fmt.Sprintf("invalid value for %s: %v", name, err))
+					}
+				}
+				continue
+			}
+		}
+		str, err := settingString(value)
+		if err == nil {
+			err = flag.Set(name, str)
+		}
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("invalid value for %s: %v", name, err))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s: %s", path, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func parseConfig(data []byte) (configFile, error) {
+	var config configFile
+	//numbers are kept as written so durations and counts are parsed by their flag
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&config.Settings); err != nil {
+		return config, err
+	}
+	if config.Settings == nil {
+		config.Settings = make(map[string]interface{})
+	}
+	if value, ok := config.Settings["defaultProfile"]; ok {
+		name, isString := value.(string)
+		if !isString {
+			return config, errors.New("defaultProfile must be a string")
+		}
+		config.DefaultProfile = name
+		delete(config.Settings, "defaultProfile")
+	}
+	if value, ok := config.Settings["profiles"]; ok {
+		profiles, isObject := value.(map[string]interface{})
+		if !isObject {
+			return config, errors.New("profiles must be an object of named profiles")
+		}
+		config.Profiles = make(map[string]map[string]interface{})
+		for name, profile := range profiles {
+			values, isObject := profile.(map[string]interface{})
+			if !isObject {
+				return config, fmt.Errorf("profile %s must be an object", name)
+			}
+			config.Profiles[name] = values
+		}
+		delete(config.Settings, "profiles")
+	}
+	return config, nil
+}
+
+//settingString converts a JSON value to its flag form, lists such as repos become comma separated
+func settingString(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return fmt.Sprint(v), nil
+	case json.Number:
+		return v.String(), nil
+	case []interface{}:
+		var items []string
+		for _, item := range v {
+			str, err := settingString(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, str)
+		}
+		return strings.Join(items, ","), nil
+	}
+	return "", errors.New("unsupported value type")
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+//ValidateFlags checks the merged flags and config before any network call is made
+func ValidateFlags(flags Flags) error {
+	var problems []string
+	if flags.TypesFileVar == "" && !flags.PreflightVar {
+		problems = append(problems, "please provide types file with -typesFile")
+	}
+	switch flags.IndexedVar {
+	case "", "unindexed", "all":
+	default:
+		problems = append(problems, "-indexed must be one of: unindexed all")
+	}
+	if flags.ReportWorkersVar < 1 {
+		problems = append(problems, "-reportWorkers must be at least 1")
+	}
+	if flags.ReindexWorkersVar < 1 {
+		problems = append(problems, "-reindexWorkers must be at least 1")
+	}
+	switch flags.DiscoveryVar {
+	case "storage", "aql":
+	default:
+		problems = append(problems, "-discovery must be one of: storage aql")
+	}
+	if flags.AQLPageSizeVar < 1 {
+		problems = append(problems, "-aqlPageSize must be at least 1")
+	}
+	if flags.BatchSizeVar < 1 {
+		problems = append(problems, "-batchSize must be at least 1")
+	}
+	if flags.XrayFailureThresholdVar < 0 {
+		problems = append(problems, "-xrayFailureThreshold must not be negative")
+	}
+	if flags.XrayFailureThresholdVar > 0 && flags.XrayProbeIntervalVar <= 0 {
+		problems = append(problems, "-xrayProbeInterval must be more than 0 while the circuit breaker is enabled by -xrayFailureThreshold")
+	}
+	if flags.RetriesVar < 0 {
+		problems = append(problems, "-retries must not be negative")
+	}
+	if flags.ArtifactoryRateVar < 0 || flags.XrayRateVar < 0 {
+		problems = append(problems, "-artifactoryRate and -xrayRate must not be negative")
+	}
+	if flags.RetryWaitVar < 0 || flags.RetryMaxWaitVar < 0 || flags.ShutdownTimeoutVar < 0 || flags.ConnectTimeoutVar < 0 || flags.ReadTimeoutVar < 0 || flags.TimeoutVar < 0 || flags.XrayProbeIntervalVar < 0 || flags.XrayMaxOutageVar < 0 {
+		problems = append(problems, "waits and timeouts must not be negative")
+	}
+	if flags.ResumeVar && (flags.StateFileVar == "" || flags.IndexedVar != "") {
+		problems = append(problems, "-resume needs -stateFile and cannot be used with -indexed")
+	}
+	if flags.ResumeVar && flags.RestartVar {
+		problems = append(problems, "-resume and -restart cannot be used together")
+	}
+	if flags.ResumeVar && flags.DryRunVar {
+		problems = append(problems, "-resume cannot be used with -dryRun")
+	}
+	if flags.DryRunFileVar != "" && !flags.DryRunVar {
+		problems = append(problems, "-dryRunFile needs -dryRun")
+	}
+	if window, err := ParseTimeWindow(flags, time.Now()); err != nil {
+		problems = append(problems, err.Error())
+	} else if window.Created() && flags.DiscoveryVar != "aql" {
+		problems = append(problems, "-createdAfter and -createdBefore need -discovery aql, the storage list has no creation times")
+	}
+	if flags.RecordVar != "" && flags.ReplayVar != "" {
+		problems = append(problems, "-record and -replay cannot be combined, a replay makes no requests to record")
+	}
+	if flags.LogHTTPVar != "" && (flags.LogHTTPVar == flags.RecordVar || flags.LogHTTPVar == flags.ReplayVar) {
+		problems = append(problems, "-logHttp must not use the -record or -replay file")
+	}
+	if (flags.ClientCertVar == "") != (flags.ClientKeyVar == "") {
+		problems = append(problems, "-clientCert and -clientKey must be provided together")
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package helpers
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestApplyConfig(t *testing.T) {
+	const config = `{
+  "reportWorkers": 5,
+  "user": "admin",
+  "repos": ["npm-local", "maven-local"],
+  "include": ["com/**", "org/**"],
+  "defaultProfile": "dev",
+  "profiles": {
+    "dev": {"url": "http://dev", "reportWorkers": 2},
+    "prod": {"url": "http://prod"}
+  }
+}`
+	tests := []struct {
+		name, config, profile string
+		//commandLine are flags given on the command line, which the config does not override
+		commandLine map[string]string
+		want        map[string]string
+		wantErr     string
+	}{
+		{"default profile", config, "", nil, map[string]string{"url": "http://dev", "reportWorkers": "2", "user": "admin", "list": "npm-local,maven-local", "include": "com/**,org/**"}, ""},
+		{"profile", config, "prod", nil, map[string]string{"url": "http://prod", "reportWorkers": "5"}, ""},
+		{"command line wins", config, "", map[string]string{"user": "bob", "reportWorkers": "9"}, map[string]string{"user": "bob", "reportWorkers": "9", "url": "http://dev"}, ""},
+		{"missing profile", config, "qa", nil, nil, "profile qa not found, available profiles: dev,prod"},
+		{"unknown setting", `{"colour": "blue"}`, "", nil, nil, "unknown setting colour"},
+		{"command line only", `{"profile": "dev"}`, "", nil, nil, "unknown setting profile"},
+		{"invalid value", `{"reportWorkers": "many"}`, "", nil, nil, "invalid value for reportWorkers"},
+		{"invalid json", `{"reportWorkers": `, "", nil, nil, "unexpected EOF"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			saved := flag.CommandLine
+			defer func() { flag.CommandLine = saved }()
+			flag.CommandLine = flag.NewFlagSet("reindex", flag.ContinueOnError)
+			var include Patterns
+			flag.String("url", "", "")
+			flag.String("user", "", "")
+			flag.String("list", "", "")
+			flag.String("profile", "", "")
+			flag.Int("reportWorkers", 1, "")
+			flag.Var(&include, "include", "")
+			for name, value := range test.commandLine {
+				if err := flag.Set(name, value); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			dir, err := ioutil.TempDir("", "config")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			path := filepath.Join(dir, "config.json")
+			if err := ioutil.WriteFile(path, []byte(test.config), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			err = applyConfig(path, test.profile)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("error = %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, want := range test.want {
+				if got := flag.Lookup(name).Value.String(); got != want {
+					t.Errorf("-%s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyConfigMissing(t *testing.T) {
+	if err := applyConfig(filepath.Join(os.TempDir(), "missing-forceReindexXray.json"), ""); err == nil {
+		t.Error("missing -config file was not reported")
+	}
+}
@@ -0,0 +1,65 @@
+package helpers
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//DryRunPlan collects the artifacts a -dryRun would have submitted. They are written to a file as JSON lines, one
+//{"repository", "path"} object each, or logged when there is no file. A nil *DryRunPlan is not a dry run
+type DryRunPlan struct {
+	Count int
+
+	file   *os.File
+	writer *bufio.Writer
+}
+
+type plannedArtifact struct {
+	Repository string `json:"repository"`
+	Path       string `json:"path"`
+}
+
+//OpenDryRunPlan starts a dry run, writing the plan to path, or to the log when path is empty
+func OpenDryRunPlan(path string) (*DryRunPlan, error) {
+	plan := &DryRunPlan{}
+	if path == "" {
+		return plan, nil
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	plan.file = file
+	plan.writer = bufio.NewWriter(file)
+	return plan, nil
+}
+
+//Add records that repo and path would have been submitted
+func (p *DryRunPlan) Add(repo, path string) error {
+	p.Count++
+	if p.writer == nil {
+		log.Info("Would reindex: ", repo+path)
+		return nil
+	}
+	data, err := json.Marshal(plannedArtifact{Repository: repo, Path: path})
+	if err != nil {
+		return err
+	}
+	_, err = p.writer.Write(append(data, '\n'))
+	return err
+}
+
+//Close flushes the plan file
+func (p *DryRunPlan) Close() error {
+	if p == nil || p.file == nil {
+		return nil
+	}
+	if err := p.writer.Flush(); err != nil {
+		p.file.Close()
+		return err
+	}
+	return p.file.Close()
+}
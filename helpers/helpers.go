@@ -3,9 +3,11 @@ package helpers
 import (
 	"flag"
 	"fmt"
+	"os"
 	"runtime"
 	"strconv"
 	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -36,7 +38,11 @@ type FileList struct {
 }
 
 type Files struct {
-	Uri string `json:"uri"`
+	Uri          string `json:"uri"`
+	Size         int64  `json:"size"`
+	LastModified string `json:"lastModified"`
+	Sha256       string `json:"sha2"`
+	Created      string `json:"created"`
 }
 
 type FileInfo struct {
@@ -68,7 +74,8 @@ func SetLogger(logLevelVar string) {
 		return fmt.Sprintf("%s\t", function), fmt.Sprintf(" %s:%d\t", repopath[len(repopath)-1], f.Line)
 	}
 
-	log.SetFormatter(customFormatter)
+	//secrets registered with RegisterSecret are masked in every entry
+	log.SetFormatter(redactingFormatter{next: customFormatter})
 	fmt.Println("Log level set at ", level)
 }
 
@@ -123,30 +130,92 @@ func Trace() TraceData {
 	return trace
 }
 
+//DefaultAQLPageSize is how many files an AQL search request returns at most
+const DefaultAQLPageSize = 10000
+
 //Flags struct
 type Flags struct {
-	UsernameVar, ApikeyVar, FolderVar, URLVar, RepoVar, LogLevelVar, TypesFileVar, IndexedVar, ListReposVar string
-	ReindexAllVar, LogUnindexableVar                                                                        bool
-	ReportWorkersVar                                                                                        int
+	UsernameVar, ApikeyVar, TokenVar, TokenFileVar, FolderVar, URLVar, RepoVar, LogLevelVar, TypesFileVar, IndexedVar, ListReposVar                   string
+	RecordVar, ReplayVar, ConfigVar, ProfileVar, ServerIDVar, ArtifactoryURLVar, XrayURLVar, CACertVar, ClientCertVar, ClientKeyVar, TLSMinVersionVar string
+	XrayVersionVar, LogHTTPVar, StateFileVar, DryRunFileVar, DiscoveryVar                                                                             string
+	CreatedAfterVar, CreatedBeforeVar, ModifiedAfterVar, ModifiedBeforeVar                                                                            string
+	ReindexAllVar, LogUnindexableVar, InsecureVar, PasswordStdinVar, PreflightVar, ResumeVar, RestartVar, DryRunVar                                   bool
+	ArtifactoryRateVar, XrayRateVar                                                                                                                   float64
+	ReportWorkersVar, RetriesVar, ArtifactoryBurstVar, XrayBurstVar, XrayFailureThresholdVar, BatchSizeVar, ReindexWorkersVar, AQLPageSizeVar         int
+	RetryWaitVar, RetryMaxWaitVar, ShutdownTimeoutVar, ConnectTimeoutVar, ReadTimeoutVar, TimeoutVar, XrayProbeIntervalVar, XrayMaxOutageVar          time.Duration
+	IncludeVar, ExcludeVar                                                                                                                            Patterns
 }
 
 //SetFlags function
 func SetFlags() Flags {
 	var flags Flags
 	flag.IntVar(&flags.ReportWorkersVar, "reportWorkers", 5, "Number of indexed report workers")
+	flag.IntVar(&flags.ReindexWorkersVar, "reindexWorkers", 1, "Number of workers submitting forceReindex batches concurrently")
+	flag.IntVar(&flags.BatchSizeVar, "batchSize", 100, "Artifacts per forceReindex request. Batches rejected because of some of their artifacts are split and retried in smaller pieces")
+	flag.IntVar(&flags.RetriesVar, "retries", 5, "Number of retries for rate limited, unavailable or reset requests")
+	flag.DurationVar(&flags.RetryWaitVar, "retryWait", time.Second, "Initial wait between retries, doubled on every attempt. A Retry-After header takes precedence")
+	flag.DurationVar(&flags.RetryMaxWaitVar, "retryMaxWait", time.Minute, "Maximum wait between retries")
+	flag.DurationVar(&flags.ShutdownTimeoutVar, "shutdownTimeout", 30*time.Second, "On SIGINT/SIGTERM, how long requests in flight may take to finish before being cancelled")
+	flag.Float64Var(&flags.ArtifactoryRateVar, "artifactoryRate", 0, "Maximum Artifactory requests per second across all workers. 0 for unlimited")
+	flag.IntVar(&flags.ArtifactoryBurstVar, "artifactoryBurst", 1, "Artifactory requests allowed in a burst above -artifactoryRate")
+	flag.Float64Var(&flags.XrayRateVar, "xrayRate", 0, "Maximum Xray requests per second across all workers, including forceReindex submissions. 0 for unlimited")
+	flag.IntVar(&flags.XrayBurstVar, "xrayBurst", 1, "Xray requests allowed in a burst above -xrayRate")
+	flag.IntVar(&flags.XrayFailureThresholdVar, "xrayFailureThreshold", 5, "Consecutive Xray failures, after retries, that pause submissions until a health check passes. 0 to disable")
+	flag.DurationVar(&flags.XrayProbeIntervalVar, "xrayProbeInterval", 10*time.Second, "Wait between Xray health checks while submissions are paused")
+	flag.DurationVar(&flags.XrayMaxOutageVar, "xrayMaxOutage", 5*time.Minute, "How long submissions may stay paused before the run is aborted")
 	flag.StringVar(&flags.IndexedVar, "indexed", "", "Indexed analysis")
 	flag.StringVar(&flags.LogLevelVar, "log", "INFO", "Order of Severity: TRACE, DEBUG, INFO, WARN, ERROR, FATAL, PANIC")
+	flag.StringVar(&flags.LogHTTPVar, "logHttp", "", "Trace every request and response with full bodies to this file, credentials redacted")
 	flag.StringVar(&flags.TypesFileVar, "typesFile", "", "supported_types.json file location, get this from Artifactory")
 	flag.StringVar(&flags.FolderVar, "folder", "", "Only reindex within a certain folder depth")
+	flag.Var(&flags.IncludeVar, "include", "Only reindex files whose path matches this glob, e.g. **/release/**. Repeatable, a file matching any of them is included")
+	flag.Var(&flags.ExcludeVar, "exclude", "Skip files whose path matches this glob, e.g. **/*-sources.jar. Repeatable, applied after -include")
 	flag.StringVar(&flags.URLVar, "url", "", "Platform URL. No /context")
+	flag.StringVar(&flags.ArtifactoryURLVar, "artifactoryUrl", "", "Artifactory base URL, context path included. Defaults to <url>/artifactory")
+	flag.StringVar(&flags.XrayURLVar, "xrayUrl", "", "Xray base URL, context path included. Defaults to <url>/xray")
+	flag.StringVar(&flags.XrayVersionVar, "xrayVersion", "", "Xray version to select endpoints for, skipping detection. Defaults to the version reported by Xray")
 	flag.StringVar(&flags.UsernameVar, "user", "", "Username")
 	flag.StringVar(&flags.ApikeyVar, "apikey", "", "API key or password")
+	flag.StringVar(&flags.TokenVar, "token", "", "Access token, sent as a Bearer token. -user is not needed")
+	flag.BoolVar(&flags.PasswordStdinVar, "passwordStdin", false, "Read the password, API key or access token from stdin")
+	flag.StringVar(&flags.ServerIDVar, "serverId", "", "JFrog CLI server ID to take the URL and credentials from. Defaults to the CLI's default server")
+	flag.StringVar(&flags.TokenFileVar, "tokenFile", "", "File containing an access token, sent as a Bearer token. -user is not needed")
+	flag.StringVar(&flags.CACertVar, "caCert", "", "PEM CA bundle to trust in addition to the system roots")
+	flag.StringVar(&flags.ClientCertVar, "clientCert", "", "PEM client certificate for mutual TLS, use with -clientKey")
+	flag.StringVar(&flags.ClientKeyVar, "clientKey", "", "PEM client private key for mutual TLS, use with -clientCert")
+	flag.StringVar(&flags.TLSMinVersionVar, "tlsMinVersion", "1.2", "Minimum TLS version: 1.0, 1.1, 1.2, 1.3")
+	flag.DurationVar(&flags.ConnectTimeoutVar, "connectTimeout", 10*time.Second, "Timeout for establishing a connection, including the TLS handshake")
+	flag.DurationVar(&flags.ReadTimeoutVar, "readTimeout", time.Minute, "Timeout waiting for response headers once a request is sent, and for each read of a streamed listing")
+	flag.DurationVar(&flags.TimeoutVar, "timeout", 10*time.Minute, "Overall timeout per request, including reading the body. 0 for none. Streamed listings are exempt")
+	flag.BoolVar(&flags.InsecureVar, "insecure", false, "Skip TLS certificate verification. Lab instances only")
 
 	flag.StringVar(&flags.RepoVar, "repo", "", "Reindex single repo")
 	flag.StringVar(&flags.ListReposVar, "list", "", "Reindex list of repos, comma separated. No white space between")
 	flag.BoolVar(&flags.ReindexAllVar, "all", false, "Reindex all repos")
 	flag.BoolVar(&flags.LogUnindexableVar, "logUnindexable", false, "Log unindexable file types in output")
+	flag.BoolVar(&flags.PreflightVar, "preflight", false, "Only check Artifactory, Xray and the permissions a run needs, then exit")
+	flag.StringVar(&flags.DiscoveryVar, "discovery", "storage", "How files are found: storage lists the whole repo at once, aql pages through an AQL search filtered by extension. aql falls back to storage when the search fails")
+	flag.IntVar(&flags.AQLPageSizeVar, "aqlPageSize", DefaultAQLPageSize, "Files fetched per AQL search request with -discovery aql")
+	flag.StringVar(&flags.CreatedAfterVar, "createdAfter", "", "Only reindex files created at or after this time, a timestamp such as 2021-07-01T12:00:00Z or a duration such as 72h back from now. Needs -discovery aql")
+	flag.StringVar(&flags.CreatedBeforeVar, "createdBefore", "", "Only reindex files created before this time, as -createdAfter. Needs -discovery aql")
+	flag.StringVar(&flags.ModifiedAfterVar, "modifiedAfter", "", "Only reindex files last modified at or after this time, as -createdAfter")
+	flag.StringVar(&flags.ModifiedBeforeVar, "modifiedBefore", "", "Only reindex files last modified before this time, as -createdAfter")
+	flag.BoolVar(&flags.DryRunVar, "dryRun", false, "List and match files as usual but only print the artifacts that would be reindexed. No request that changes anything is sent")
+	flag.StringVar(&flags.DryRunFileVar, "dryRunFile", "", "Write the artifacts -dryRun would reindex to this file as JSON lines instead of logging them")
+
+	flag.StringVar(&flags.StateFileVar, "stateFile", DefaultStateFile, "File the progress of -all, -list and -repo runs is saved to, removed once the run completes. Empty to disable")
+	flag.BoolVar(&flags.ResumeVar, "resume", false, "Continue the run saved in -stateFile, skipping repos and files already submitted")
+	flag.BoolVar(&flags.RestartVar, "restart", false, "Start over, discarding the unfinished run saved in -stateFile instead of refusing to overwrite it")
+	flag.StringVar(&flags.RecordVar, "record", "", "Record every request and response to this cassette file, credentials redacted")
+	flag.StringVar(&flags.ReplayVar, "replay", "", "Serve the run from a cassette file written by -record, without network access")
+	flag.StringVar(&flags.ConfigVar, "config", "", "JSON config file of settings and named profiles, keyed by flag name. Defaults to ~/"+DefaultConfigFile+" if present")
+	flag.StringVar(&flags.ProfileVar, "profile", "", "Profile of the config file to use, overrides its defaultProfile")
 
 	flag.Parse()
+	//flags given on the command line take precedence over the config file
+	if err := applyConfig(flags.ConfigVar, flags.ProfileVar); err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid config:", err)
+		os.Exit(2)
+	}
 	return flags
 }
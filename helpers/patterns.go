@@ -0,0 +1,84 @@
+package helpers
+
+import (
+	"regexp"
+	"strings"
+)
+
+//Patterns is a repeatable flag of glob patterns matched against artifact uris, relative to the repo root. * matches
+//within a path segment, ? a single character and ** any number of segments, so **/*-sources.jar matches sources jars
+//at any depth
+type Patterns struct {
+	globs   []string
+	regexps []*regexp.Regexp
+}
+
+func (p *Patterns) String() string {
+	if p == nil {
+		return ""
+	}
+	return strings.Join(p.globs, ",")
+}
+
+//Set adds a pattern, each use of the flag adds one
+func (p *Patterns) Set(glob string) error {
+	re, err := globRegexp(glob)
+	if err != nil {
+		return err
+	}
+	p.globs = append(p.globs, glob)
+	p.regexps = append(p.regexps, re)
+	return nil
+}
+
+//Len is the number of patterns
+func (p Patterns) Len() int {
+	return len(p.globs)
+}
+
+//Match reports whether uri matches any of the patterns
+func (p Patterns) Match(uri string) bool {
+	uri = strings.TrimPrefix(uri, "/")
+	for _, re := range p.regexps {
+		if re.MatchString(uri) {
+			return true
+		}
+	}
+	return false
+}
+
+//Selected reports whether uri passes the -include and -exclude patterns. With no include patterns every uri not
+//excluded is selected
+func Selected(uri string, include, exclude Patterns) bool {
+	if include.Len() > 0 && !include.Match(uri) {
+		return false
+	}
+	return !exclude.Match(uri)
+}
+
+func globRegexp(glob string) (*regexp.Regexp, error) {
+	glob = strings.TrimPrefix(glob, "/")
+	var re strings.Builder
+	re.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			re.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "/**") && i+3 == len(glob):
+			re.WriteString("(?:/.*)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			re.WriteString(".*")
+			i++
+		case glob[i] == '*':
+			re.WriteString("[^/]*")
+		case glob[i] == '?':
+			re.WriteString("[^/]")
+		default:
+			re.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	re.WriteString("$")
+	return regexp.Compile(re.String())
+}
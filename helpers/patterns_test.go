@@ -0,0 +1,68 @@
+package helpers
+
+import "testing"
+
+func TestGlobRegexp(t *testing.T) {
+	tests := []struct {
+		glob, uri string
+		want      bool
+	}{
+		{"*.jar", "app.jar", true},
+		{"*.jar", "com/app.jar", false},
+		{"**/*.jar", "app.jar", true},
+		{"**/*-sources.jar", "com/acme/app/1.0/app-1.0-sources.jar", true},
+		{"**/*-sources.jar", "com/acme/app/1.0/app-1.0.jar", false},
+		{"com/**", "com/acme/app.jar", true},
+		{"com/**", "com", true},
+		{"com/**", "org/acme/app.jar", false},
+		{"com/**/app.jar", "com/app.jar", true},
+		{"com/**/app.jar", "com/acme/web/app.jar", true},
+		{"a?c.tgz", "abc.tgz", true},
+		{"a?c.tgz", "a/c.tgz", false},
+		{"/lodash/*", "lodash/lodash-4.17.21.tgz", true},
+		{"a+b(1).tgz", "a+b(1).tgz", true},
+		{"a.tgz", "aatgz", false},
+	}
+	for _, test := range tests {
+		re, err := globRegexp(test.glob)
+		if err != nil {
+			t.Errorf("globRegexp(%q): %v", test.glob, err)
+			continue
+		}
+		if got := re.MatchString(test.uri); got != test.want {
+			t.Errorf("%q matching %q = %t, want %t (%s)", test.glob, test.uri, got, test.want, re)
+		}
+	}
+}
+
+func TestSelected(t *testing.T) {
+	var include, exclude, none Patterns
+	for _, glob := range []string{"com/**", "org/**"} {
+		if err := include.Set(glob); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := exclude.Set("**/*-sources.jar"); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		uri              string
+		include, exclude Patterns
+		want             bool
+	}{
+		{"/com/app.jar", none, none, true},
+		{"/com/app.jar", include, none, true},
+		{"/net/app.jar", include, none, false},
+		{"/com/app-sources.jar", include, exclude, false},
+		{"/net/app.jar", none, exclude, true},
+		{"/net/app-sources.jar", none, exclude, false},
+	}
+	for _, test := range tests {
+		if got := Selected(test.uri, test.include, test.exclude); got != test.want {
+			t.Errorf("Selected(%q, %s, %s) = %t, want %t", test.uri, test.include.String(), test.exclude.String(), got, test.want)
+		}
+	}
+	if include.String() != "com/**,org/**" || include.Len() != 2 {
+		t.Errorf("include is %q with %d patterns", include.String(), include.Len())
+	}
+}
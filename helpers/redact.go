@@ -0,0 +1,103 @@
+package helpers
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//Redacted replaces masked headers and secrets in logs and traces
+const Redacted = "REDACTED"
+
+//MaxLoggedBody is how much of a body is logged before it is truncated
+const MaxLoggedBody = 512
+
+//sensitiveHeaders carry credentials and are never logged
+var sensitiveHeaders = map[string]bool{
+	"Authorization":   true,
+	"X-Jfrog-Art-Api": true,
+	"Cookie":          true,
+	"Set-Cookie":      true,
+}
+
+var secrets struct {
+	sync.RWMutex
+	values []string
+}
+
+//RegisterSecret masks secret, and the basic auth encoding of user:secret when user is given, in every log entry
+func RegisterSecret(user, secret string) {
+	if secret == "" {
+		return
+	}
+	secrets.Lock()
+	defer secrets.Unlock()
+	secrets.values = append(secrets.values, secret)
+	if user != "" {
+		secrets.values = append(secrets.values, base64.StdEncoding.EncodeToString([]byte(user+":"+secret)))
+	}
+}
+
+//Redact masks every registered secret in s
+func Redact(s string) string {
+	secrets.RLock()
+	defer secrets.RUnlock()
+	for _, secret := range secrets.values {
+		s = strings.Replace(s, secret, Redacted, -1)
+	}
+	return s
+}
+
+//IsSensitiveHeader reports whether the header carries credentials
+func IsSensitiveHeader(name string) bool {
+	return sensitiveHeaders[http.CanonicalHeaderKey(name)]
+}
+
+//RedactHeader returns value, or Redacted for headers carrying credentials
+func RedactHeader(name, value string) string {
+	if IsSensitiveHeader(name) {
+		return Redacted
+	}
+	return Redact(value)
+}
+
+//RedactHeaders copies headers with credentials masked
+func RedactHeaders(headers http.Header) http.Header {
+	if len(headers) == 0 {
+		return nil
+	}
+	copied := headers.Clone()
+	for name := range copied {
+		if IsSensitiveHeader(name) {
+			copied.Set(name, Redacted)
+		}
+	}
+	return copied
+}
+
+//TruncateBody returns the start of a body for logging, with the full size when it is cut. The whole body is
+//redacted before it is cut, so a secret straddling the cut cannot leak partly
+func TruncateBody(data []byte) string {
+	body := Redact(string(data))
+	if len(body) <= MaxLoggedBody {
+		return body
+	}
+	return body[:MaxLoggedBody] + fmt.Sprintf("... (%d bytes)", len(data))
+}
+
+//redactingFormatter masks registered secrets in every formatted entry
+type redactingFormatter struct {
+	next log.Formatter
+}
+
+func (f redactingFormatter) Format(entry *log.Entry) ([]byte, error) {
+	formatted, err := f.next.Format(entry)
+	if err != nil {
+		return formatted, err
+	}
+	return []byte(Redact(string(formatted))), nil
+}
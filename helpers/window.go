@@ -0,0 +1,118 @@
+package helpers
+
+import (
+	"fmt"
+	"time"
+)
+
+//TimeWindow selects files by when they were created and last modified. After bounds are inclusive, Before bounds
+//exclusive, and zero times are unbounded
+type TimeWindow struct {
+	CreatedAfter   time.Time `json:"createdAfter"`
+	CreatedBefore  time.Time `json:"createdBefore"`
+	ModifiedAfter  time.Time `json:"modifiedAfter"`
+	ModifiedBefore time.Time `json:"modifiedBefore"`
+}
+
+//timestampLayouts are the absolute times accepted by the window flags, and the formats Artifactory reports
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.000-0700",
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+}
+
+//ParseTimeWindow reads the -createdAfter, -createdBefore, -modifiedAfter and -modifiedBefore flags. Durations such as
+//72h are taken back from now
+func ParseTimeWindow(flags Flags, now time.Time) (TimeWindow, error) {
+	var window TimeWindow
+	bounds := []struct {
+		name  string
+		value string
+		time  *time.Time
+	}{
+		{"createdAfter", flags.CreatedAfterVar, &window.CreatedAfter},
+		{"createdBefore", flags.CreatedBeforeVar, &window.CreatedBefore},
+		{"modifiedAfter", flags.ModifiedAfterVar, &window.ModifiedAfter},
+		{"modifiedBefore", flags.ModifiedBeforeVar, &window.ModifiedBefore},
+	}
+	for _, bound := range bounds {
+		if bound.value == "" {
+			continue
+		}
+		t, err := parseWindowTime(bound.value, now)
+		if err != nil {
+			return window, fmt.Errorf("-%s %q is neither a timestamp such as 2021-07-01T12:00:00Z nor a duration such as 72h", bound.name, bound.value)
+		}
+		*bound.time = t
+	}
+	if !window.CreatedAfter.IsZero() && !window.CreatedBefore.IsZero() && !window.CreatedAfter.Before(window.CreatedBefore) {
+		return window, fmt.Errorf("-createdAfter must be earlier than -createdBefore")
+	}
+	if !window.ModifiedAfter.IsZero() && !window.ModifiedBefore.IsZero() && !window.ModifiedAfter.Before(window.ModifiedBefore) {
+		return window, fmt.Errorf("-modifiedAfter must be earlier than -modifiedBefore")
+	}
+	return window, nil
+}
+
+func parseWindowTime(value string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	return parseTimestamp(value)
+}
+
+func parseTimestamp(value string) (time.Time, error) {
+	var err error
+	for _, layout := range timestampLayouts {
+		var t time.Time
+		if t, err = time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
+
+//Empty reports whether the window selects every file
+func (w TimeWindow) Empty() bool {
+	return !w.Created() && w.ModifiedAfter.IsZero() && w.ModifiedBefore.IsZero()
+}
+
+//Created reports whether the window has a bound on the creation time
+func (w TimeWindow) Created() bool {
+	return !w.CreatedAfter.IsZero() || !w.CreatedBefore.IsZero()
+}
+
+//Contains reports whether file falls inside the window. Files missing a timestamp the window needs are outside it
+func (w TimeWindow) Contains(file Files) bool {
+	return w.within(file.Created, w.CreatedAfter, w.CreatedBefore) && w.within(file.LastModified, w.ModifiedAfter, w.ModifiedBefore)
+}
+
+func (w TimeWindow) within(timestamp string, after, before time.Time) bool {
+	if after.IsZero() && before.IsZero() {
+		return true
+	}
+	t, err := parseTimestamp(timestamp)
+	if err != nil {
+		return false
+	}
+	return !t.Before(after) && (before.IsZero() || t.Before(before))
+}
+
+//String describes the bounds of the window for the log
+func (w TimeWindow) String() string {
+	var desc string
+	bound := func(name string, t time.Time) {
+		if !t.IsZero() {
+			if desc != "" {
+				desc += " "
+			}
+			desc += name + " " + t.UTC().Format(time.RFC3339)
+		}
+	}
+	bound("created after", w.CreatedAfter)
+	bound("created before", w.CreatedBefore)
+	bound("modified after", w.ModifiedAfter)
+	bound("modified before", w.ModifiedBefore)
+	return desc
+}
@@ -0,0 +1,61 @@
+package helpers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimeWindow(t *testing.T) {
+	now := time.Date(2021, 7, 10, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		flags   Flags
+		want    TimeWindow
+		wantErr bool
+	}{
+		{"empty", Flags{}, TimeWindow{}, false},
+		{"timestamp", Flags{CreatedAfterVar: "2021-07-01T12:00:00Z"}, TimeWindow{CreatedAfter: time.Date(2021, 7, 1, 12, 0, 0, 0, time.UTC)}, false},
+		{"date", Flags{ModifiedBeforeVar: "2021-07-01"}, TimeWindow{ModifiedBefore: time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC)}, false},
+		{"duration", Flags{ModifiedAfterVar: "72h"}, TimeWindow{ModifiedAfter: now.Add(-72 * time.Hour)}, false},
+		{"both bounds", Flags{CreatedAfterVar: "48h", CreatedBeforeVar: "24h"}, TimeWindow{CreatedAfter: now.Add(-48 * time.Hour), CreatedBefore: now.Add(-24 * time.Hour)}, false},
+		{"reversed created", Flags{CreatedAfterVar: "24h", CreatedBeforeVar: "48h"}, TimeWindow{}, true},
+		{"reversed modified", Flags{ModifiedAfterVar: "2021-07-02", ModifiedBeforeVar: "2021-07-01"}, TimeWindow{}, true},
+		{"invalid", Flags{ModifiedAfterVar: "last week"}, TimeWindow{}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			window, err := ParseTimeWindow(test.flags, now)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("error = %v, want error %t", err, test.wantErr)
+			}
+			if !test.wantErr && window != test.want {
+				t.Errorf("got %s, want %s", window, test.want)
+			}
+		})
+	}
+}
+
+func TestTimeWindowContains(t *testing.T) {
+	after := time.Date(2021, 7, 1, 0, 0, 0, 0, time.UTC)
+	before := time.Date(2021, 7, 2, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		window TimeWindow
+		file   Files
+		want   bool
+	}{
+		{"empty window", TimeWindow{}, Files{}, true},
+		{"inside", TimeWindow{ModifiedAfter: after, ModifiedBefore: before}, Files{LastModified: "2021-07-01T10:00:00.000Z"}, true},
+		{"after is inclusive", TimeWindow{ModifiedAfter: after}, Files{LastModified: "2021-07-01T00:00:00.000Z"}, true},
+		{"before is exclusive", TimeWindow{ModifiedBefore: before}, Files{LastModified: "2021-07-02T00:00:00.000Z"}, false},
+		{"too old", TimeWindow{ModifiedAfter: after}, Files{LastModified: "2021-06-30T23:59:59.000Z"}, false},
+		{"artifactory offset", TimeWindow{CreatedAfter: after}, Files{Created: "2021-07-01T03:00:00.000+0200"}, true},
+		{"missing timestamp", TimeWindow{CreatedAfter: after}, Files{LastModified: "2021-07-01T10:00:00.000Z"}, false},
+		{"both bounds", TimeWindow{CreatedAfter: after, ModifiedBefore: before}, Files{Created: "2021-07-01T10:00:00Z", LastModified: "2021-07-03T10:00:00Z"}, false},
+	}
+	for _, test := range tests {
+		if got := test.window.Contains(test.file); got != test.want {
+			t.Errorf("%s: Contains = %t, want %t", test.name, got, test.want)
+		}
+	}
+}
@@ -1,21 +1,21 @@
 package main
 
 import (
-	"container/list"
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/lorenyeung/forceReindexXray/auth"
 	"github.com/lorenyeung/forceReindexXray/helpers"
-	"github.com/lorenyeung/forceReindexXray/internal"
 
 	log "github.com/sirupsen/logrus"
-	"golang.org/x/crypto/ssh/terminal"
 )
 
 var gitCommit string
@@ -30,60 +30,157 @@ func main() {
 	versionFlag := flag.Bool("v", false, "Print the current version and exit")
 	flags := helpers.SetFlags()
 	helpers.SetLogger(flags.LogLevelVar)
+	auth.SetRetryPolicy(auth.RetryPolicy{MaxAttempts: flags.RetriesVar + 1, BaseDelay: flags.RetryWaitVar, MaxDelay: flags.RetryMaxWaitVar})
 
 	switch {
 	case *versionFlag:
 		printVersion()
 		return
 	}
+	if err := helpers.ValidateFlags(flags); err != nil {
+		log.Fatal("Invalid flags: ", err)
+	}
+	auth.SetShutdownGrace(flags.ShutdownTimeoutVar)
+	auth.SetBreakerOptions(auth.BreakerOptions{
+		Threshold:     flags.XrayFailureThresholdVar,
+		ProbeInterval: flags.XrayProbeIntervalVar,
+		MaxOpen:       flags.XrayMaxOutageVar,
+	})
+	auth.SetClientOptions(auth.ClientOptions{
+		ConnectTimeout: flags.ConnectTimeoutVar,
+		ReadTimeout:    flags.ReadTimeoutVar,
+		Timeout:        flags.TimeoutVar,
+		MaxConns:       maxInt(flags.ReportWorkersVar, flags.ReindexWorkersVar),
+		KeepAlive:      30 * time.Second,
+	})
+	auth.SetRateLimits(auth.RateLimits{
+		ArtifactoryRate:  flags.ArtifactoryRateVar,
+		ArtifactoryBurst: flags.ArtifactoryBurstVar,
+		XrayRate:         flags.XrayRateVar,
+		XrayBurst:        flags.XrayBurstVar,
+	})
+	err := auth.SetTLSConfig(auth.TLSOptions{
+		CACertFile:         flags.CACertVar,
+		ClientCertFile:     flags.ClientCertVar,
+		ClientKeyFile:      flags.ClientKeyVar,
+		MinVersion:         flags.TLSMinVersionVar,
+		InsecureSkipVerify: flags.InsecureVar,
+	})
+	if err != nil {
+		log.Fatal("Invalid TLS configuration: ", err)
+	}
+	if flags.ReplayVar != "" {
+		if err := auth.ReplayFrom(flags.ReplayVar); err != nil {
+			log.Fatal("Invalid replay cassette: ", err)
+		}
+		log.Info("Replaying requests from ", flags.ReplayVar, ", no network calls are made")
+	}
+	if flags.RecordVar != "" {
+		if err := auth.RecordTo(flags.RecordVar); err != nil {
+			log.Fatal("Could not create cassette: ", err)
+		}
+		defer auth.CloseCassette()
+		log.Info("Recording requests to ", flags.RecordVar)
+	}
+	if flags.LogHTTPVar != "" {
+		if err := auth.LogHTTPTo(flags.LogHTTPVar); err != nil {
+			log.Fatal("Could not create HTTP log: ", err)
+		}
+		defer auth.CloseHTTPLog()
+		log.Info("Tracing HTTP requests to ", flags.LogHTTPVar)
+	}
+	//durations in the time window flags count back from the start of the run
+	window, _ := helpers.ParseTimeWindow(flags, timeStart)
+	if !window.Empty() {
+		log.Info("Only reindexing files ", window)
+	}
+	var plan *helpers.DryRunPlan
+	if flags.DryRunVar {
+		auth.SetReadOnly(true)
+		plan, err = helpers.OpenDryRunPlan(flags.DryRunFileVar)
+		if err != nil {
+			log.Fatal("Could not create dry run file: ", err)
+		}
+		log.Info("Dry run, nothing will be submitted to Xray")
+	}
+	ctx := cancelOnSignal(flags.ShutdownTimeoutVar)
 
 	var supportTypesFile helpers.SupportedTypes
 
-	if flags.TypesFileVar == "" {
-		log.Fatalf("Please provide types file with -typesFile")
-	}
 	if flags.TypesFileVar != "" {
 		credsFile, err := os.Open(flags.TypesFileVar)
 		if err != nil {
-			log.Fatalf("Invalid creds file:", err)
+			log.Fatal("Invalid types file:", err)
 		}
 		defer credsFile.Close()
 		scanner, _ := ioutil.ReadAll(credsFile)
-		json.Unmarshal(scanner, &supportTypesFile)
+		if err := json.Unmarshal(scanner, &supportTypesFile); err != nil {
+			log.Fatal("Invalid types file:", err)
+		}
 	}
 	if flags.FolderVar != "" && !strings.HasPrefix(flags.FolderVar, "/") {
 		log.Info("Missing prefix forward slash on folder path, adding in.")
 		flags.FolderVar = "/" + flags.FolderVar
 	}
-	var creds auth.Creds
-	if flags.ApikeyVar == "" {
-		fmt.Println("Enter password or API key: ")
-		password, err := terminal.ReadPassword(0)
-		if err == nil {
-			creds.Apikey = string(password)
-		}
-	} else {
-		creds.Apikey = flags.ApikeyVar
+	creds, err := auth.ResolveCreds(flags)
+	if err != nil {
+		log.Fatal("Could not resolve credentials: ", err)
 	}
+	log.Debug("Artifactory URL:", creds.ArtifactoryURL, " Xray URL:", creds.XrayURL)
 
-	if flags.UsernameVar == "" || flags.URLVar == "" {
-		log.Fatalf("Please specify -user, AND -url flags")
+	artifactory := auth.NewArtifactoryClient(creds)
+	if err := artifactory.Ping(ctx); err != nil {
+		log.Fatal("Please verify your URL and/or credentials. Do not provide context paths in -url, use -artifactoryUrl and -xrayUrl instead. Ping failed with ", err)
+	}
+	xray := negotiateXray(ctx, creds, flags.XrayVersionVar)
+	checks, results := auth.Preflight(ctx, artifactory, xray, flags.IndexedVar == "")
+	log.Info("Preflight checks:")
+	for _, check := range checks {
+		if check.Err != nil {
+			log.Error("FAIL ", check.Name, ": ", check.Err)
+		} else {
+			log.Info("PASS ", check.Name, " ", check.Detail)
+		}
+	}
+	if !auth.PreflightPassed(checks) {
+		log.Fatal("Preflight failed, no work was started")
+	}
+	if flags.PreflightVar {
+		log.Info("Preflight passed")
+		return
 	}
 
-	creds.Username = flags.UsernameVar
-	creds.URL = flags.URLVar
-
-	if !auth.VerifyAPIKey(creds.URL, creds.Username, creds.Apikey) {
-		log.Fatalf("Please verify your URL and/or credentials. Do not provide context paths in your URL.")
+	//progress of reindex runs is saved so -resume can skip what was already submitted
+	var checkpoint *helpers.Checkpoint
+	if flags.IndexedVar == "" && flags.StateFileVar != "" && plan == nil && (flags.ReindexAllVar || flags.ListReposVar != "" || flags.RepoVar != "") {
+		//the window flags are keyed as given, a duration such as 72h selects the same files on -resume
+		selection := fmt.Sprintf("all=%t list=%s repo=%s folder=%s discovery=%s createdAfter=%s createdBefore=%s modifiedAfter=%s modifiedBefore=%s include=%s exclude=%s",
+			flags.ReindexAllVar, flags.ListReposVar, flags.RepoVar, flags.FolderVar, flags.DiscoveryVar,
+			flags.CreatedAfterVar, flags.CreatedBeforeVar, flags.ModifiedAfterVar, flags.ModifiedBeforeVar, flags.IncludeVar.String(), flags.ExcludeVar.String())
+		checkpoint, err = helpers.OpenCheckpoint(flags.StateFileVar, creds.ArtifactoryURL+" "+creds.XrayURL, selection, window, flags.ResumeVar, flags.RestartVar)
+		if err != nil {
+			log.Fatal("Invalid state file: ", err)
+		}
+		if flags.ResumeVar {
+			log.Info("Resuming the run saved in ", flags.StateFileVar)
+			//durations resolve to the times of the saved run, not from now
+			window = checkpoint.Window
+			if !window.Empty() {
+				log.Info("Only reindexing files ", window, ", as in the saved run")
+			}
+		}
 	}
-	results := auth.CheckTypeAndRepoParams(creds)
 
 	if flags.ReindexAllVar {
 		//index all
 		log.Info("Indexing all repos")
 		for i := range results {
+			if interrupted(ctx) {
+				log.Warn("Interrupted, skipping the remaining ", len(results)-i, " repos")
+				break
+			}
 			log.Info("Indexing ", results[i].Name)
-			indexRepo(results[i].Name, results[i].PkgType, supportTypesFile, creds, results[i].Type, flags)
+			indexRepo(ctx, results[i].Name, results[i].PkgType, supportTypesFile, artifactory, xray, results[i].Type, flags, window, checkpoint, plan)
 		}
 
 	} else if flags.ListReposVar != "" {
@@ -91,13 +188,17 @@ func main() {
 		log.Info("Indexing specified list of repos:", flags.ListReposVar)
 		list := strings.Split(flags.ListReposVar, ",")
 		for i := range list {
+			if interrupted(ctx) {
+				log.Warn("Interrupted, skipping the remaining ", len(list)-i, " repos")
+				break
+			}
 			log.Debug("Removing -cache as needed")
 			list[i] = strings.TrimSuffix(list[i], "-cache")
 			var found bool
 			for j := range results {
 				if results[j].Name == list[i] {
 					log.Info("Repo is in indexed list:", list[i])
-					indexRepo(results[j].Name, results[j].PkgType, supportTypesFile, creds, results[j].Type, flags)
+					indexRepo(ctx, results[j].Name, results[j].PkgType, supportTypesFile, artifactory, xray, results[j].Type, flags, window, checkpoint, plan)
 					found = true
 					break
 				}
@@ -117,7 +218,7 @@ func main() {
 			if results[i].Name == flags.RepoVar {
 				log.Info("Repo is in indexed list")
 				found = true
-				indexRepo(flags.RepoVar, results[i].PkgType, supportTypesFile, creds, results[i].Type, flags)
+				indexRepo(ctx, flags.RepoVar, results[i].PkgType, supportTypesFile, artifactory, xray, results[i].Type, flags, window, checkpoint, plan)
 				break
 			}
 		}
@@ -141,135 +242,108 @@ func main() {
 			}
 		}
 	}
+	if ctx.Err() != nil {
+		log.Warn("Run was interrupted, the totals above are partial")
+	}
+	if checkpoint != nil {
+		if interrupted(ctx) || checkpoint.FailedCount() > 0 {
+			log.Info("Progress saved to ", flags.StateFileVar, ", rerun with -resume to continue and retry ", checkpoint.FailedCount(), " failed files")
+		} else if err := checkpoint.Remove(); err != nil {
+			log.Warn("Could not remove state file: ", err)
+		}
+	}
+	if plan != nil {
+		if err := plan.Close(); err != nil {
+			log.Error("Could not write dry run file: ", err)
+		}
+		if flags.DryRunFileVar != "" {
+			log.Info("Dry run, ", plan.Count, " artifacts would have been reindexed, written to ", flags.DryRunFileVar)
+		} else {
+			log.Info("Dry run, ", plan.Count, " artifacts would have been reindexed")
+		}
+	}
 	endTime := time.Now()
 	totalTime := endTime.Sub(timeStart)
 	log.Info("Execution took:", totalTime)
-}
-
-func indexRepo(repo string, pkgType string, types helpers.SupportedTypes, creds auth.Creds, repoType string, flags helpers.Flags) {
-	var extensions []helpers.Extensions
-	pkgType = strings.ToLower(pkgType)
-	log.Debug("type:", repoType, " pkgType:", pkgType, " repo:", repo)
-	for i := range types.SupportedPackageTypes {
-		if types.SupportedPackageTypes[i].Type == pkgType {
-			log.Debug("found package type:", types.SupportedPackageTypes[i].Type)
-			extensions = types.SupportedPackageTypes[i].Extension
-		}
+	if err := auth.XrayUnavailable(); err != nil {
+		log.Fatal("Run aborted, the totals above are partial. ", err)
 	}
+}
 
-	var repoMap = make(map[string]bool)
-	for y := range extensions {
-		repoMap[extensions[y].Extension] = true
-		log.Debug("Extension added to list:", extensions[y].Extension)
-	}
-	var fileListData []byte
-	var respCode int
-	if repoType == "remote" {
-		repo = repo + "-cache"
+func maxInt(a, b int) int {
+	if a > b {
+		return a
 	}
-	fileListData, respCode, _ = auth.GetRestAPI("GET", true, creds.URL+"/artifactory/api/storage/"+repo+flags.FolderVar+"?list&deep=1", creds.Username, creds.Apikey, "", nil, 0)
-	if respCode != 200 {
-		log.Fatalf("File list received unexpected response code:", respCode, " :", string(fileListData))
-	}
-	log.Debug("File list received:", string(fileListData))
-
-	var UnindexableMap = make(map[string]int)
-	var fileListStruct helpers.FileList
-	json.Unmarshal(fileListData, &fileListStruct)
-	var notIndexCount, totalCount, notIndexableCount, noExtCount int
-	indexAnalysis := list.New()
-	for i := range fileListStruct.Files {
-		for j := range extensions {
-			fileListStruct.Files[i].Uri = flags.FolderVar + fileListStruct.Files[i].Uri
-
-			log.Debug("File found:", fileListStruct.Files[i].Uri, " matching against:", extensions[j].Extension)
-			if strings.Contains(fileListStruct.Files[i].Uri, extensions[j].Extension) {
+	return b
+}
 
-				if flags.IndexedVar != "" {
-					var queueDetails queueDetails
-					queueDetails.Repo = repo
-					queueDetails.PkgType = pkgType
-					queueDetails.Types = types
-					queueDetails.Creds = creds
-					queueDetails.RepoType = repoType
-					queueDetails.Flags = flags
-					queueDetails.FileListData = fileListStruct.Files[i]
-					queueDetails.NotIndexCount = notIndexCount
-					queueDetails.TotalCount = totalCount
-					indexAnalysis.PushBack(queueDetails)
-					//notIndexCount, totalCount = Details(repo, pkgType, types, creds, repoType, flags, fileListStruct.Files[i], notIndexCount, totalCount)
-				} else {
-					log.Info("File being sent to indexing:", fileListStruct.Files[i].Uri)
-					//send to indexing
-					m := map[string]string{
-						"Content-Type": "application/json",
-					}
-					body := "{\"artifacts\": [{\"repository\":\"" + repo + "\",\"path\":\"" + fileListStruct.Files[i].Uri + "\"}]}"
+//interrupted reports whether new work should not be started, after a signal or once Xray is given up on
+func interrupted(ctx context.Context) bool {
+	return ctx.Err() != nil || auth.XrayUnavailable() != nil
+}
 
-					resp, respCode, _ := auth.GetRestAPI("POST", true, creds.URL+"/xray/api/v1/forceReindex", creds.Username, creds.Apikey, body, m, 0)
-					if respCode != 200 {
-						notIndexCount++
-						log.Warn("Unexpected Xray response:HTTP", respCode, " ", string(resp))
-					} else {
-						log.Info("Xray response:", string(resp))
-					}
-					totalCount++
-				}
-				break
-			} else if j+1 == len(extensions) {
-				//failed the last match
-				if flags.LogUnindexableVar {
-					log.Info("not indexable:", fileListStruct.Files[i].Uri)
-				}
-				filePath := strings.Split(fileListStruct.Files[i].Uri, "/")
-				fileName := filePath[len(filePath)-1]
-				fileExt := strings.Split(fileName, ".")
-				notIndexableCount++
-				log.Debug("name, name array, uri:", fileName, fileExt, " ", fileListStruct.Files[i].Uri)
-				if len(fileExt)-1 > 0 {
-					//dont add files without file ext
-					UnindexableMap["."+fileExt[len(fileExt)-1]]++
-				} else {
-					noExtCount++
-				}
+//cancelOnSignal returns a context that is cancelled on SIGINT or SIGTERM, so no new work is started.
+//Requests already in flight get the grace period to finish, a second signal exits straight away
+func cancelOnSignal(grace time.Duration) context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.Warn("Received ", sig, ", stopping. Waiting up to ", grace, " for requests in flight, signal again to exit now")
+		cancel()
+		sig = <-signals
+		log.Fatal("Received ", sig, " again, exiting")
+	}()
+	return ctx
+}
 
-			}
+//negotiateXray detects the Xray version, unless given with -xrayVersion, and returns a client using its endpoints
+func negotiateXray(ctx context.Context, creds auth.Creds, override string) auth.XrayClient {
+	var version auth.XrayVersion
+	var err error
+	if override != "" {
+		version, err = auth.ParseXrayVersion(override)
+		if err != nil {
+			log.Fatal("Invalid -xrayVersion: ", err)
+		}
+	} else {
+		version, err = auth.NewXrayClient(creds).Version(ctx)
+		if err != nil {
+			log.Warn("Could not detect the Xray version, assuming 3.0 endpoints. Use -xrayVersion to set it. ", err)
+			return auth.NewXrayClient(creds)
 		}
 	}
-
-	numJobs := indexAnalysis.Len()
-	jobs := make(chan int, numJobs)
-	results := make(chan int, numJobs)
-
-	//worker pool
-	for w := 1; w <= flags.ReportWorkersVar; w++ {
-		go worker(w, jobs, results, indexAnalysis)
-	}
-	for j := 1; j <= numJobs; j++ {
-		jobs <- j
+	warning, err := auth.CheckXrayVersion(version)
+	if err != nil {
+		log.Fatal(err)
 	}
-	close(jobs)
-	var x int
-	for a := 1; a <= numJobs; a++ {
-		x = <-results
-		if x == 0 {
-			notIndexCount++
-		}
-		totalCount++
+	if warning != "" {
+		log.Warn(warning)
 	}
+	log.Info("Xray version:", version)
+	return auth.NewXrayClientForVersion(creds, version)
+}
 
-	log.Info("Total indexed count:", totalCount-notIndexCount, "/", totalCount, " Total not indexable:", notIndexableCount, " Files with no extension:", noExtCount)
-	log.Info("Unindexable file types count:", UnindexableMap)
+//reportResult is what a report worker reports for a file. Err is the status or file info lookup that failed, the
+//file is still reported with what could be found
+type reportResult struct {
+	Indexed bool
+	//Skipped is set for jobs the worker did not start because the run was cancelled
+	Skipped bool
+	Err     error
 }
 
-func worker(id int, jobs <-chan int, results chan<- int, queue *list.List) {
-	for queue.Len() > 0 {
-		e := queue.Front().Value
-		queue.Remove(queue.Front())
+func worker(ctx context.Context, id int, jobs <-chan queueDetails, results chan<- reportResult) {
+	for e := range jobs {
+		if interrupted(ctx) {
+			results <- reportResult{Skipped: true}
+			continue
+		}
 		log.Debug("worker ", id, " working on ", e)
-		notIndexCount, totalCount := Details(e.(queueDetails))
+		notIndexCount, totalCount, err := Details(ctx, e)
 		log.Debug("not index:", notIndexCount, " total:", totalCount)
-		results <- totalCount
+		results <- reportResult{Indexed: totalCount > 0, Err: err}
 	}
 }
 
@@ -278,7 +352,8 @@ type queueDetails struct {
 	Repo          string
 	PkgType       string
 	Types         helpers.SupportedTypes
-	Creds         auth.Creds
+	Artifactory   auth.ArtifactoryClient
+	Xray          auth.XrayClient
 	RepoType      string
 	Flags         helpers.Flags
 	FileListData  helpers.Files
@@ -286,7 +361,8 @@ type queueDetails struct {
 	TotalCount    int
 }
 
-func Details(q queueDetails) (int, int) {
+//Details prints the status of a file, returning the first lookup that failed
+func Details(ctx context.Context, q queueDetails) (int, int, error) {
 	//send to details
 	var printAll bool
 	switch q.Flags.IndexedVar {
@@ -296,45 +372,59 @@ func Details(q queueDetails) (int, int) {
 	default:
 		log.Fatalf("Please provide one of the following: unindexed all")
 	}
-	status, proc := internal.GetDetails(q.Repo, q.PkgType, q.FileListData.Uri, q.Creds)
-	if !proc {
+	status, err := q.Xray.ArtifactStatus(ctx, q.Repo, q.FileListData.Uri)
+	if err != nil {
+		log.Warn("Could not get status of ", q.Repo+q.FileListData.Uri, ", ", auth.Category(err), " error: ", err)
+		status.Status = "UNKNOWN"
+	}
+	var infoErr error
+	if !status.Indexed {
 		q.NotIndexCount++
-		printStatus(status, q.Repo, q.PkgType, q.FileListData.Uri, q.Creds)
+		infoErr = printStatus(ctx, status.Status, q.Repo, q.PkgType, q.FileListData.Uri, q.Artifactory)
 	} else {
 		q.TotalCount++
 		if printAll {
-			printStatus(status, q.Repo, q.PkgType, q.FileListData.Uri, q.Creds)
+			infoErr = printStatus(ctx, status.Status, q.Repo, q.PkgType, q.FileListData.Uri, q.Artifactory)
 		}
 	}
+	if err == nil {
+		err = infoErr
+	}
 	//log.Info("not index:", q.NotIndexCount, " total:", q.TotalCount)
-	return q.NotIndexCount, q.TotalCount
+	return q.NotIndexCount, q.TotalCount, err
 }
 
-func printStatus(status string, repo string, pkgType string, uri string, creds auth.Creds) {
-	var fileDetails []byte
+//printStatus logs a status line for the file, returning the file info lookup that failed
+func printStatus(ctx context.Context, status string, repo string, pkgType string, uri string, artifactory auth.ArtifactoryClient) error {
 	var fileInfo helpers.FileInfo
 	var size string
+	var err error
 	if pkgType == "docker" {
 		uri = strings.TrimSuffix(uri, "/manifest.json")
-		folderDetails, _, _ := auth.GetRestAPI("GET", true, creds.URL+"/artifactory/api/storage/"+repo+uri, creds.Username, creds.Apikey, "", nil, 0)
-		json.Unmarshal(folderDetails, &fileInfo)
+		fileInfo, err = artifactory.FileInfo(ctx, repo, uri)
 		var size64 int64
 		for i := range fileInfo.Children {
 			path := fileInfo.Children[i].Uri
-			var fileInfoDocker helpers.FileInfo
-			fileDetailsDocker, _, _ := auth.GetRestAPI("GET", true, creds.URL+"/artifactory/api/storage/"+repo+uri+path, creds.Username, creds.Apikey, "", nil, 0)
-			json.Unmarshal(fileDetailsDocker, &fileInfoDocker)
+			fileInfoDocker, childErr := artifactory.FileInfo(ctx, repo, uri+path)
+			if childErr != nil {
+				err = childErr
+			}
 			size64 = size64 + helpers.StringToInt64(fileInfoDocker.Size)
 		}
 		//hardcode mimetype for now
 		fileInfo.MimeType = "application/json"
 		size = helpers.ByteCountDecimal(size64)
+		if err != nil {
+			log.Warn("Size of ", repo+uri, " is incomplete, ", auth.Category(err), " error: ", err)
+		}
 	} else {
-		fileDetails, _, _ = auth.GetRestAPI("GET", true, creds.URL+"/artifactory/api/storage/"+repo+uri, creds.Username, creds.Apikey, "", nil, 0)
-		json.Unmarshal(fileDetails, &fileInfo)
+		if fileInfo, err = artifactory.FileInfo(ctx, repo, uri); err != nil {
+			log.Warn("Could not get file info for ", repo+uri, ", ", auth.Category(err), " error: ", err)
+		}
 		size = helpers.ByteCountDecimal(helpers.StringToInt64(fileInfo.Size))
 	}
 	status = fmt.Sprintf("%-19v", status)
 	//not really helpful for docker
 	log.Info(status, "\t", size, "\t", fmt.Sprintf("%-16v", strings.TrimPrefix(fileInfo.MimeType, "application/")), " ", repo+uri)
+	return err
 }
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/lorenyeung/forceReindexXray/mockserver"
+)
+
+//runToolEnv makes the test binary run the tool itself, so each run gets fresh flags and globals
+const runToolEnv = "REINDEX_TEST_RUN_TOOL"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(runToolEnv) == "1" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+//runTool runs the tool against server with the mock credentials and supported types, returning its output
+func runTool(t *testing.T, server *mockserver.Server, args ...string) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "reindex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	typesFile := filepath.Join(dir, "supported_types.json")
+	if err := ioutil.WriteFile(typesFile, []byte(mockserver.SupportedTypes), 0644); err != nil {
+		t.Fatal(err)
+	}
+	args = append([]string{
+		"-url", server.URL,
+		"-user", mockserver.Username,
+		"-apikey", mockserver.Password,
+		"-typesFile", typesFile,
+		"-stateFile", filepath.Join(dir, "state.json"),
+		"-retries", "0",
+	}, args...)
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), runToolEnv+"=1")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("reindex %s failed: %v\n%s", strings.Join(args, " "), err, output)
+	}
+	return string(output)
+}
+
+func reindexedPaths(server *mockserver.Server) []string {
+	var paths []string
+	for _, artifact := range server.Reindexed() {
+		paths = append(paths, artifact.Repository+artifact.Path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func TestReindexRepo(t *testing.T) {
+	server := mockserver.New(mockserver.DefaultRepos())
+	defer server.Close()
+
+	output := runTool(t, server, "-repo", "npm-local")
+	if !strings.Contains(output, "Total indexed count:2/2") {
+		t.Errorf("unexpected totals:\n%s", output)
+	}
+	want := []string{"npm-local/left-pad/-/left-pad-1.3.0.tgz", "npm-local/lodash/-/lodash-4.17.21.tgz"}
+	if got := reindexedPaths(server); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("reindexed %v, want %v", got, want)
+	}
+	requests := server.Requests()
+	if requests["POST forceReindex"] == 0 {
+		t.Errorf("forceReindex was not called: %v", requests)
+	}
+	if requests["POST v2/index"] != 0 {
+		t.Errorf("v2 index was called on Xray %s: %v", mockserver.DefaultXrayVersion, requests)
+	}
+}
+
+func TestReindexV2Index(t *testing.T) {
+	server := mockserver.New(mockserver.DefaultRepos())
+	defer server.Close()
+	server.SetXrayVersion("4.1.0")
+
+	runTool(t, server, "-repo", "npm-local")
+	requests := server.Requests()
+	//one request per artifact, plus the preflight permission probe
+	if requests["POST v2/index"] != 3 {
+		t.Errorf("v2 index called %d times, want 3: %v", requests["POST v2/index"], requests)
+	}
+	if requests["POST forceReindex"] != 0 {
+		t.Errorf("forceReindex was called on Xray 4.1.0: %v", requests)
+	}
+	if got := len(server.Reindexed()); got != 2 {
+		t.Errorf("reindexed %d artifacts, want 2", got)
+	}
+}
+
+func TestReindexV2IndexRejected(t *testing.T) {
+	server := mockserver.New(mockserver.DefaultRepos())
+	defer server.Close()
+	server.SetXrayVersion("4.1.0")
+
+	output := runTool(t, server, "-repo", "npm-legacy", "-batchSize", "4")
+	if !strings.Contains(output, "Total indexed count:3/4") {
+		t.Errorf("unexpected totals:\n%s", output)
+	}
+	//each artifact is reported on its own, so the rejected one is not submitted again in split batches
+	if requests := server.Requests(); requests["POST v2/index"] != 5 {
+		t.Errorf("v2 index called %d times, want 5: %v", requests["POST v2/index"], requests)
+	}
+	if got := len(server.Reindexed()); got != 3 {
+		t.Errorf("reindexed %d artifacts, want 3", got)
+	}
+}
+
+func TestDryRunSubmitsNothing(t *testing.T) {
+	server := mockserver.New(mockserver.DefaultRepos())
+	defer server.Close()
+
+	output := runTool(t, server, "-repo", "npm-local", "-dryRun")
+	if !strings.Contains(output, "Would reindex: npm-local/lodash/-/lodash-4.17.21.tgz") {
+		t.Errorf("dry run did not report the plan:\n%s", output)
+	}
+	if got := server.Reindexed(); len(got) != 0 {
+		t.Errorf("dry run reindexed %v", got)
+	}
+	if requests := server.Requests(); requests["POST forceReindex"]+requests["POST v2/index"] != 0 {
+		t.Errorf("dry run submitted to Xray: %v", requests)
+	}
+}
+
+func TestReindexList(t *testing.T) {
+	server := mockserver.New(mockserver.DefaultRepos())
+	defer server.Close()
+
+	output := runTool(t, server, "-list", "npm-local,docker-local")
+	if !strings.Contains(output, "Indexing specified list of repos:npm-local,docker-local") {
+		t.Errorf("list was not indexed:\n%s", output)
+	}
+	want := []string{"docker-local/app/1.0/manifest.json", "npm-local/left-pad/-/left-pad-1.3.0.tgz", "npm-local/lodash/-/lodash-4.17.21.tgz"}
+	if got := reindexedPaths(server); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("reindexed %v, want %v", got, want)
+	}
+}
+
+func TestReindexAll(t *testing.T) {
+	server := mockserver.New(mockserver.DefaultRepos())
+	defer server.Close()
+
+	output := runTool(t, server, "-all")
+	if !strings.Contains(output, "Not submitted: npm-legacy/c/-/c-0.0.1.tgz") {
+		t.Errorf("rejected artifact was not reported:\n%s", output)
+	}
+	repos := make(map[string]int)
+	for _, artifact := range server.Reindexed() {
+		repos[artifact.Repository]++
+	}
+	want := map[string]int{"npm-local": 2, "maven-local": 3, "docker-local": 1, "npm-legacy": 3, "jcenter-cache": 1}
+	for repo, count := range want {
+		if repos[repo] != count {
+			t.Errorf("reindexed %d artifacts of %s, want %d: %v", repos[repo], repo, count, repos)
+		}
+	}
+	if len(repos) != len(want) {
+		t.Errorf("reindexed repos %v, want %v", repos, want)
+	}
+}
+
+func TestIndexedReport(t *testing.T) {
+	tests := []struct {
+		name, repo, mode string
+		want             []string
+		notWant          string
+	}{
+		{"unindexed", "maven-local", "unindexed", []string{"FAILED", "maven-local/com/acme/web/2.0/web-2.0.war", "Total indexed count:2/3"}, "app-1.0.jar"},
+		{"all", "npm-local", "all", []string{"DONE", "NOT_SCANNED", "npm-local/lodash/-/lodash-4.17.21.tgz", "Total indexed count:1/2"}, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := mockserver.New(mockserver.DefaultRepos())
+			defer server.Close()
+
+			output := runTool(t, server, "-repo", test.repo, "-indexed", test.mode)
+			for _, want := range test.want {
+				if !strings.Contains(output, want) {
+					t.Errorf("output is missing %q:\n%s", want, output)
+				}
+			}
+			if test.notWant != "" && strings.Contains(output, test.notWant) {
+				t.Errorf("output reports %q:\n%s", test.notWant, output)
+			}
+			requests := server.Requests()
+			if requests["POST artifact/status"] == 0 {
+				t.Errorf("artifact status was not called: %v", requests)
+			}
+			//reports never submit anything
+			if len(server.Reindexed()) != 0 || requests["POST forceReindex"] != 0 {
+				t.Errorf("-indexed submitted to Xray: %v", requests)
+			}
+		})
+	}
+}
+
+func TestIndexedReportFailures(t *testing.T) {
+	server := mockserver.New(mockserver.DefaultRepos())
+	defer server.Close()
+	//the preflight ping and status probe pass, every status lookup after them fails
+	server.FailXray(2, -1)
+
+	output := runTool(t, server, "-repo", "npm-local", "-indexed", "all")
+	for _, want := range []string{"UNKNOWN", "Total indexed count:0/2", "Failed requests by category:map[server:2]"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("output is missing %q:\n%s", want, output)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package mockserver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// aqlQuery matches the items.find queries the reindex tool sends, capturing the criteria, offset and limit
+var aqlQuery = regexp.MustCompile(`^items\.find\((.*)\)\.include\([^)]*\)\.sort\([^)]*\)\.offset\((\d+)\)\.limit\((\d+)\)$`)
+
+// DisableAQL makes the AQL search endpoint answer 404, as on instances where it is unavailable
+func (s *Server) DisableAQL(disabled bool) {
+	s.mu.Lock()
+	s.aqlDisabled = disabled
+	s.mu.Unlock()
+}
+
+// aql serves AQL items searches. Only the subset of the language the reindex tool uses is understood: criteria on
+// repo, path, name, type, created and modified with $and, $or, $match and the comparisons, sorted by path and name, paged with offset and limit
+func (s *Server) aql(w http.ResponseWriter, r *http.Request) {
+	s.count(r, "search/aql")
+	s.mu.Lock()
+	disabled := s.aqlDisabled
+	s.mu.Unlock()
+	if disabled {
+		http.Error(w, `{"errors":[{"status":404,"message":"Not Found"}]}`, http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	match := aqlQuery.FindStringSubmatch(strings.TrimSpace(string(body)))
+	if match == nil {
+		http.Error(w, `{"errors":[{"status":400,"message":"Failed to parse query"}]}`, http.StatusBadRequest)
+		return
+	}
+	var criteria map[string]interface{}
+	if err := json.Unmarshal([]byte(match[1]), &criteria); err != nil {
+		http.Error(w, `{"errors":[{"status":400,"message":"Failed to parse query"}]}`, http.StatusBadRequest)
+		return
+	}
+	offset, _ := strconv.Atoi(match[2])
+	limit, _ := strconv.Atoi(match[3])
+
+	type item struct {
+		Repo     string `json:"repo"`
+		Path     string `json:"path"`
+		Name     string `json:"name"`
+		Type     string `json:"type"`
+		Size     int64  `json:"size"`
+		Sha256   string `json:"sha256"`
+		Modified string `json:"modified"`
+		Created  string `json:"created"`
+	}
+	items := []item{}
+	for _, repo := range s.repos {
+		storageName := repo.Name
+		if repo.Type == "remote" {
+			storageName += "-cache"
+		}
+		for _, file := range repo.Files {
+			dir, name := "", file.Path
+			if i := strings.LastIndex(file.Path, "/"); i >= 0 {
+				dir, name = strings.Trim(file.Path[:i], "/"), file.Path[i+1:]
+			}
+			if dir == "" {
+				dir = "."
+			}
+			created := file.Created
+			if created.IsZero() {
+				created = file.LastModified
+			}
+			modified := file.LastModified.UTC().Format("2006-01-02T15:04:05.000Z")
+			fields := map[string]string{
+				"repo":     storageName,
+				"path":     dir,
+				"name":     name,
+				"type":     "file",
+				"modified": modified,
+				"created":  created.UTC().Format("2006-01-02T15:04:05.000Z"),
+			}
+			if aqlMatches(criteria, fields) {
+				items = append(items, item{
+					Repo:     storageName,
+					Path:     dir,
+					Name:     name,
+					Type:     "file",
+					Size:     file.Size,
+					Sha256:   checksum(storageName, file.Path),
+					Modified: modified,
+					Created:  fields["created"],
+				})
+			}
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Path != items[j].Path {
+			return items[i].Path < items[j].Path
+		}
+		return items[i].Name < items[j].Name
+	})
+	start := len(items)
+	if offset < start {
+		start = offset
+	}
+	end := len(items)
+	if start+limit < end {
+		end = start + limit
+	}
+	writeJSON(w, map[string]interface{}{
+		"results": items[start:end],
+		"range":   map[string]int{"start_pos": start, "end_pos": end, "total": end - start, "limit": limit},
+	})
+}
+
+// aqlMatches evaluates criteria against the fields of an item
+func aqlMatches(criteria map[string]interface{}, fields map[string]string) bool {
+	for key, value := range criteria {
+		switch key {
+		case "$and", "$or":
+			clauses, _ := value.([]interface{})
+			matchedAny := false
+			for _, clause := range clauses {
+				clause, _ := clause.(map[string]interface{})
+				matched := aqlMatches(clause, fields)
+				if key == "$and" && !matched {
+					return false
+				}
+				matchedAny = matchedAny || matched
+			}
+			if key == "$or" && !matchedAny {
+				return false
+			}
+		default:
+			switch value := value.(type) {
+			case string:
+				if fields[key] != value {
+					return false
+				}
+			case map[string]interface{}:
+				for operator, operand := range value {
+					operand, _ := operand.(string)
+					if !aqlCompare(operator, fields[key], operand) {
+						return false
+					}
+				}
+			default:
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// aqlCompare applies an AQL operator. Dates are all in the same UTC layout, so they compare as strings
+func aqlCompare(operator, field, operand string) bool {
+	switch operator {
+	case "$match":
+		return wildcardMatch(operand, field)
+	case "$eq":
+		return field == operand
+	case "$ne":
+		return field != operand
+	case "$gt":
+		return field > operand
+	case "$gte":
+		return field >= operand
+	case "$lt":
+		return field < operand
+	case "$lte":
+		return field <= operand
+	}
+	return false
+}
+
+// wildcardMatch matches AQL $match patterns, where * matches any run of characters including /
+func wildcardMatch(pattern, value string) bool {
+	parts := strings.Split(pattern, "*")
+	for i := range parts {
+		parts[i] = regexp.QuoteMeta(parts[i])
+	}
+	return regexp.MustCompile("^" + strings.Join(parts, ".*") + "$").MatchString(value)
+}
+
+// checksum is a stable sha256 for a seeded file, which has no content
+func checksum(repo, filePath string) string {
+	sum := sha256.Sum256([]byte(repo + filePath))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,482 @@
+// Package mockserver is a fake JFrog platform implementing the Artifactory and Xray endpoints the
+// reindex tool calls, seeded with repositories, for integration testing without a real instance
+package mockserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Credentials accepted by a new Server, either as basic auth or as a Bearer token
+const (
+	Username = "admin"
+	Password = "password"
+	Token    = "token"
+	//Reader can use Artifactory with Password but is denied the Xray reindex and status endpoints
+	Reader = "reader"
+)
+
+// DefaultXrayVersion is the version a new Server reports
+const DefaultXrayVersion = "3.51.3"
+
+// File is an artifact stored in a seeded repository
+type File struct {
+	Path         string
+	Size         int64
+	MimeType     string
+	LastModified time.Time
+	//Created is reported by AQL searches, LastModified when zero
+	Created time.Time
+	//Status is what the Xray artifact status endpoint reports, DONE when empty
+	Status string
+	//Rejected files fail any reindex request they are part of with a 400
+	Rejected bool
+}
+
+// Repo is a seeded repository. Remote repositories are listed with the -cache suffix
+type Repo struct {
+	Name    string
+	PkgType string
+	Type    string
+	Files   []File
+}
+
+// Artifact is one entry of a forceReindex request
+type Artifact struct {
+	Repository string `json:"repository"`
+	Path       string `json:"path"`
+}
+
+// Server is a running mock platform. Platform URL is Server.URL
+type Server struct {
+	*httptest.Server
+	mu          sync.Mutex
+	repos       []Repo
+	reindexed   []Artifact
+	requests    map[string]int
+	xrayVersion string
+	//xrayFailures is how many Xray requests still fail with a 500 once xrayPasses more have succeeded,
+	//negative for all of them
+	xrayPasses, xrayFailures int
+	aqlDisabled              bool
+}
+
+// New starts a mock platform serving repos
+func New(repos []Repo) *Server {
+	s := &Server{repos: repos, requests: make(map[string]int), xrayVersion: DefaultXrayVersion}
+	s.Server = httptest.NewServer(s.handler())
+	return s
+}
+
+// NewUnstarted returns a mock platform serving repos whose listener can be configured before calling Start
+func NewUnstarted(repos []Repo) *Server {
+	s := &Server{repos: repos, requests: make(map[string]int), xrayVersion: DefaultXrayVersion}
+	s.Server = httptest.NewUnstartedServer(s.handler())
+	return s
+}
+
+// FailXray lets the next after Xray requests succeed, then fails count requests with a 500. A negative count fails
+// all of them, 0 none. The version endpoint keeps working
+func (s *Server) FailXray(after, count int) {
+	s.mu.Lock()
+	s.xrayPasses, s.xrayFailures = after, count
+	s.mu.Unlock()
+}
+
+// SetXrayVersion changes the version reported by Xray. The v2 index endpoint is only served from 3.76
+func (s *Server) SetXrayVersion(version string) {
+	s.mu.Lock()
+	s.xrayVersion = version
+	s.mu.Unlock()
+}
+
+// Reindexed returns every artifact submitted to forceReindex so far
+func (s *Server) Reindexed() []Artifact {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Artifact(nil), s.reindexed...)
+}
+
+// Requests returns how often each endpoint was called, keyed by method and route
+func (s *Server) Requests() map[string]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counts := make(map[string]int, len(s.requests))
+	for route, count := range s.requests {
+		counts[route] = count
+	}
+	return counts
+}
+
+func (s *Server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/artifactory/api/system/ping", s.ping)
+	mux.HandleFunc("/artifactory/api/xrayRepo/getIndex", s.getIndex)
+	mux.HandleFunc("/artifactory/api/storage/", s.storage)
+	mux.HandleFunc("/artifactory/api/search/aql", s.aql)
+	mux.HandleFunc("/xray/api/v1/forceReindex", s.forceReindex)
+	mux.HandleFunc("/xray/api/v1/artifact/status", s.artifactStatus)
+	mux.HandleFunc("/xray/api/v1/system/version", s.version)
+	mux.HandleFunc("/xray/api/v1/system/ping", s.xrayPing)
+	mux.HandleFunc("/xray/api/v2/index", s.index)
+	mux.HandleFunc("/mock/reindexed", s.listReindexed)
+	mux.HandleFunc("/mock/xrayVersion", s.setVersion)
+	mux.HandleFunc("/mock/xrayFailures", s.setFailures)
+	mux.HandleFunc("/mock/aql", s.setAQL)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := authorized(r)
+		if !strings.HasPrefix(r.URL.Path, "/mock/") && !ok {
+			http.Error(w, `{"errors":[{"status":401,"message":"Bad credentials"}]}`, http.StatusUnauthorized)
+			return
+		}
+		if user == Reader && strings.HasPrefix(r.URL.Path, "/xray/") && !strings.HasPrefix(r.URL.Path, "/xray/api/v1/system/") {
+			http.Error(w, `{"error":"Forbidden"}`, http.StatusForbidden)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/xray/") && r.URL.Path != "/xray/api/v1/system/version" && s.failXray() {
+			s.count(r, "xray failure")
+			http.Error(w, `{"error":"Internal Server Error"}`, http.StatusInternalServerError)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// failXray reports whether an Xray request should fail, using up one injected failure
+func (s *Server) failXray() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.xrayPasses > 0 {
+		s.xrayPasses--
+		return false
+	}
+	if s.xrayFailures > 0 {
+		s.xrayFailures--
+		return true
+	}
+	return s.xrayFailures < 0
+}
+
+// authorized returns the user of a request with valid credentials, empty for tokens
+func authorized(r *http.Request) (string, bool) {
+	if user, password, ok := r.BasicAuth(); ok {
+		return user, (user == Username || user == Reader) && password == Password
+	}
+	return "", r.Header.Get("Authorization") == "Bearer "+Token
+}
+
+func (s *Server) count(r *http.Request, route string) {
+	s.mu.Lock()
+	s.requests[r.Method+" "+route]++
+	s.mu.Unlock()
+}
+
+func (s *Server) ping(w http.ResponseWriter, r *http.Request) {
+	s.count(r, "system/ping")
+	fmt.Fprint(w, "OK")
+}
+
+func (s *Server) getIndex(w http.ResponseWriter, r *http.Request) {
+	s.count(r, "xrayRepo/getIndex")
+	type indexedRepo struct {
+		Name    string `json:"name"`
+		PkgType string `json:"pkgType"`
+		Type    string `json:"type"`
+	}
+	indexed := []indexedRepo{}
+	for _, repo := range s.repos {
+		indexed = append(indexed, indexedRepo{repo.Name, repo.PkgType, repo.Type})
+	}
+	writeJSON(w, indexed)
+}
+
+// storage serves both the deep file list (?list&deep=1) and file or folder info
+func (s *Server) storage(w http.ResponseWriter, r *http.Request) {
+	repoPath := strings.TrimPrefix(r.URL.Path, "/artifactory/api/storage/")
+	repoName, folder := repoPath, "/"
+	if i := strings.Index(repoPath, "/"); i >= 0 {
+		repoName, folder = repoPath[:i], path.Clean(repoPath[i:])
+	}
+	repo, ok := s.findStorage(repoName)
+	if !ok {
+		s.count(r, "storage")
+		http.Error(w, `{"errors":[{"status":404,"message":"Not Found"}]}`, http.StatusNotFound)
+		return
+	}
+	if _, list := r.URL.Query()["list"]; list {
+		s.count(r, "storage?list")
+		s.listFiles(w, r, repoName, repo, folder)
+		return
+	}
+	s.count(r, "storage")
+	s.fileInfo(w, r, repoName, repo, folder)
+}
+
+func (s *Server) findStorage(name string) (Repo, bool) {
+	for _, repo := range s.repos {
+		storageName := repo.Name
+		if repo.Type == "remote" {
+			storageName += "-cache"
+		}
+		if storageName == name {
+			return repo, true
+		}
+	}
+	return Repo{}, false
+}
+
+func (s *Server) listFiles(w http.ResponseWriter, r *http.Request, repoName string, repo Repo, folder string) {
+	type listedFile struct {
+		URI          string `json:"uri"`
+		Size         int64  `json:"size"`
+		LastModified string `json:"lastModified"`
+		Folder       bool   `json:"folder"`
+		Sha2         string `json:"sha2"`
+	}
+	prefix := strings.TrimSuffix(folder, "/") + "/"
+	files := []listedFile{}
+	for _, file := range repo.Files {
+		if !strings.HasPrefix(file.Path, prefix) {
+			continue
+		}
+		//uris are relative to the listed folder
+		files = append(files, listedFile{
+			URI:          "/" + strings.TrimPrefix(file.Path, prefix),
+			Size:         file.Size,
+			LastModified: file.LastModified.UTC().Format("2006-01-02T15:04:05.000Z"),
+			Sha2:         checksum(repoName, file.Path),
+		})
+	}
+	writeJSON(w, map[string]interface{}{
+		"uri":     "http://" + r.Host + r.URL.Path,
+		"created": time.Now().UTC().Format("2006-01-02T15:04:05.000Z"),
+		"files":   files,
+	})
+}
+
+func (s *Server) fileInfo(w http.ResponseWriter, r *http.Request, repoName string, repo Repo, filePath string) {
+	for _, file := range repo.Files {
+		if file.Path == filePath {
+			writeJSON(w, map[string]string{
+				"repo":         repoName,
+				"path":         file.Path,
+				"lastModified": file.LastModified.UTC().Format("2006-01-02T15:04:05.000Z"),
+				"mimeType":     file.MimeType,
+				"size":         fmt.Sprint(file.Size),
+			})
+			return
+		}
+	}
+	//otherwise a folder, listing its direct children
+	type child struct {
+		URI    string `json:"uri"`
+		Folder bool   `json:"folder"`
+	}
+	prefix := strings.TrimSuffix(filePath, "/") + "/"
+	seen := make(map[string]bool)
+	children := []child{}
+	for _, file := range repo.Files {
+		if !strings.HasPrefix(file.Path, prefix) {
+			continue
+		}
+		rest := strings.TrimPrefix(file.Path, prefix)
+		name := strings.SplitN(rest, "/", 2)[0]
+		if !seen[name] {
+			seen[name] = true
+			children = append(children, child{URI: "/" + name, Folder: strings.Contains(rest, "/")})
+		}
+	}
+	if len(children) == 0 {
+		http.Error(w, `{"errors":[{"status":404,"message":"Not Found"}]}`, http.StatusNotFound)
+		return
+	}
+	sort.Slice(children, func(i, j int) bool { return children[i].URI < children[j].URI })
+	writeJSON(w, map[string]interface{}{"repo": repoName, "path": filePath, "children": children})
+}
+
+func (s *Server) forceReindex(w http.ResponseWriter, r *http.Request) {
+	s.count(r, "forceReindex")
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var request struct {
+		Artifacts []Artifact `json:"artifacts"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || len(request.Artifacts) == 0 {
+		http.Error(w, `{"error":"Bad request"}`, http.StatusBadRequest)
+		return
+	}
+	if !s.reindex(w, request.Artifacts) {
+		return
+	}
+	writeJSON(w, map[string]string{"info": fmt.Sprintf("Reindex of %d artifacts was sent", len(request.Artifacts))})
+}
+
+// index is the v2 Scan Now endpoint taking a single repo path
+func (s *Server) index(w http.ResponseWriter, r *http.Request) {
+	s.count(r, "v2/index")
+	if !s.versionAtLeast(3, 76) {
+		http.Error(w, "404 page not found", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var request struct {
+		RepoPath string `json:"repo_path"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || !strings.Contains(request.RepoPath, "/") {
+		http.Error(w, `{"error":"Bad request"}`, http.StatusBadRequest)
+		return
+	}
+	i := strings.Index(request.RepoPath, "/")
+	if !s.reindex(w, []Artifact{{Repository: request.RepoPath[:i], Path: request.RepoPath[i:]}}) {
+		return
+	}
+	writeJSON(w, map[string]string{"info": "Scan of artifact is in progress"})
+}
+
+// reindex records artifacts, or writes an error response if one of them does not exist
+func (s *Server) reindex(w http.ResponseWriter, artifacts []Artifact) bool {
+	for _, artifact := range artifacts {
+		repo, ok := s.findStorage(artifact.Repository)
+		file, found := findFile(repo, artifact.Path)
+		if !ok || !found {
+			http.Error(w, `{"error":"Artifact not found: `+artifact.Repository+artifact.Path+`"}`, http.StatusNotFound)
+			return false
+		}
+		if file.Rejected {
+			http.Error(w, `{"error":"Artifact cannot be indexed: `+artifact.Repository+artifact.Path+`"}`, http.StatusBadRequest)
+			return false
+		}
+	}
+	s.mu.Lock()
+	s.reindexed = append(s.reindexed, artifacts...)
+	s.mu.Unlock()
+	return true
+}
+
+func (s *Server) version(w http.ResponseWriter, r *http.Request) {
+	s.count(r, "system/version")
+	s.mu.Lock()
+	version := s.xrayVersion
+	s.mu.Unlock()
+	writeJSON(w, map[string]string{"xray_version": version, "xray_revision": "mock"})
+}
+
+func (s *Server) xrayPing(w http.ResponseWriter, r *http.Request) {
+	s.count(r, "xray system/ping")
+	writeJSON(w, map[string]string{"status": "pong"})
+}
+
+func (s *Server) versionAtLeast(major, minor int) bool {
+	s.mu.Lock()
+	version := s.xrayVersion
+	s.mu.Unlock()
+	var gotMajor, gotMinor int
+	fmt.Sscanf(version, "%d.%d", &gotMajor, &gotMinor)
+	return gotMajor > major || (gotMajor == major && gotMinor >= minor)
+}
+
+// setVersion changes the reported Xray version to the request body
+func (s *Server) setVersion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.SetXrayVersion(strings.TrimSpace(string(body)))
+}
+
+// setFailures injects Xray failures, the request body is "<after> <count>" as passed to FailXray
+func (s *Server) setFailures(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var after, count int
+	if _, err := fmt.Fscan(r.Body, &after, &count); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.FailXray(after, count)
+}
+
+// setAQL turns the AQL search endpoint on or off, the request body is "on" or "off"
+func (s *Server) setAQL(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.DisableAQL(strings.TrimSpace(string(body)) == "off")
+}
+
+// artifactStatus answers the Xray artifact scan status endpoint used for -indexed reports
+func (s *Server) artifactStatus(w http.ResponseWriter, r *http.Request) {
+	s.count(r, "artifact/status")
+	var request struct {
+		Repo string `json:"repo"`
+		Path string `json:"path"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, `{"error":"Bad request"}`, http.StatusBadRequest)
+		return
+	}
+	repo, ok := s.findStorage(request.Repo)
+	if !ok {
+		http.Error(w, `{"error":"Repository not found"}`, http.StatusNotFound)
+		return
+	}
+	filePath := "/" + strings.TrimPrefix(request.Path, "/")
+	for _, file := range repo.Files {
+		if file.Path == filePath {
+			status := file.Status
+			if status == "" {
+				status = "DONE"
+			}
+			writeJSON(w, map[string]interface{}{
+				"overall": map[string]string{"status": status, "time": file.LastModified.UTC().Format(time.RFC3339)},
+				"details": map[string]interface{}{"sca": map[string]string{"status": status}},
+			})
+			return
+		}
+	}
+	http.Error(w, `{"error":"Artifact not found"}`, http.StatusNotFound)
+}
+
+func (s *Server) listReindexed(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.Reindexed())
+}
+
+func findFile(repo Repo, filePath string) (File, bool) {
+	for _, file := range repo.Files {
+		if file.Path == filePath {
+			return file, true
+		}
+	}
+	return File{}, false
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,52 @@
+package mockserver
+
+import "time"
+
+// SupportedTypes is a supported_types.json covering the seeded package types, for -typesFile
+const SupportedTypes = `{
+  "supportedPackageTypes": [
+    {"type": "maven", "extensions": [{"extension": ".jar", "is_file": false}, {"extension": ".war", "is_file": false}, {"extension": ".ear", "is_file": false}]},
+    {"type": "npm", "extensions": [{"extension": ".tgz", "is_file": false}]},
+    {"type": "docker", "extensions": [{"extension": "manifest.json", "is_file": true}]}
+  ]
+}
+`
+
+// DefaultRepos seeds local npm, maven and docker repositories and a remote maven repository. Files without a
+// supported extension are there to exercise the unindexable counts, and some are reported as not yet indexed.
+// npm-legacy holds a package Xray rejects, failing every batch it is part of
+func DefaultRepos() []Repo {
+	day := func(d int) time.Time {
+		return time.Date(2021, time.July, d, 12, 0, 0, 0, time.UTC)
+	}
+	return []Repo{
+		{Name: "npm-local", PkgType: "Npm", Type: "local", Files: []File{
+			{Path: "/left-pad/-/left-pad-1.3.0.tgz", Size: 2048, MimeType: "application/x-compressed", LastModified: day(1)},
+			{Path: "/lodash/-/lodash-4.17.21.tgz", Size: 315000, MimeType: "application/x-compressed", LastModified: day(5), Status: "NOT_SCANNED"},
+			{Path: "/.npm/left-pad/package.json", Size: 900, MimeType: "application/json", LastModified: day(1)},
+		}},
+		{Name: "maven-local", PkgType: "Maven", Type: "local", Files: []File{
+			{Path: "/com/acme/app/1.0/app-1.0.jar", Size: 1200000, MimeType: "application/java-archive", LastModified: day(2)},
+			{Path: "/com/acme/app/1.0/app-1.0-sources.jar", Size: 300000, MimeType: "application/java-archive", LastModified: day(2)},
+			{Path: "/com/acme/app/1.0/app-1.0.pom", Size: 1500, MimeType: "application/x-maven-pom+xml", LastModified: day(2)},
+			{Path: "/com/acme/web/2.0/web-2.0.war", Size: 5400000, MimeType: "application/java-archive", LastModified: day(10), Created: day(8), Status: "FAILED"},
+			{Path: "/com/acme/app/maven-metadata.xml", Size: 400, MimeType: "application/xml", LastModified: day(10)},
+			{Path: "/com/acme/README", Size: 100, MimeType: "text/plain", LastModified: day(10)},
+		}},
+		{Name: "docker-local", PkgType: "Docker", Type: "local", Files: []File{
+			{Path: "/app/1.0/manifest.json", Size: 1100, MimeType: "application/json", LastModified: day(3)},
+			{Path: "/app/1.0/sha256__a1b2c3", Size: 25000000, MimeType: "application/octet-stream", LastModified: day(3)},
+			{Path: "/app/1.0/sha256__d4e5f6", Size: 1500, MimeType: "application/octet-stream", LastModified: day(3)},
+		}},
+		{Name: "npm-legacy", PkgType: "Npm", Type: "local", Files: []File{
+			{Path: "/a/-/a-1.0.0.tgz", Size: 1000, MimeType: "application/x-compressed", LastModified: day(6)},
+			{Path: "/b/-/b-1.0.0.tgz", Size: 1000, MimeType: "application/x-compressed", LastModified: day(6)},
+			{Path: "/c/-/c-0.0.1.tgz", Size: 1000, MimeType: "application/x-compressed", LastModified: day(6), Rejected: true},
+			{Path: "/d/-/d-1.0.0.tgz", Size: 1000, MimeType: "application/x-compressed", LastModified: day(6)},
+		}},
+		{Name: "jcenter", PkgType: "Maven", Type: "remote", Files: []File{
+			{Path: "/junit/junit/4.12/junit-4.12.jar", Size: 314932, MimeType: "application/java-archive", LastModified: day(4)},
+			{Path: "/junit/junit/4.12/junit-4.12.pom", Size: 24000, MimeType: "application/x-maven-pom+xml", LastModified: day(4)},
+		}},
+	}
+}
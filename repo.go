@@ -0,0 +1,513 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/lorenyeung/forceReindexXray/auth"
+	"github.com/lorenyeung/forceReindexXray/helpers"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//fileQueueSize is how many listed files may wait to be matched, so memory stays flat however large the repo
+const fileQueueSize = 1000
+
+//repoRun reindexes, reports on or dry runs one repo. The goroutine calling run matches the files as the listing
+//goroutine sends them, the reindex workers submit batches and the report workers look up statuses. Each of them
+//keeps its own counts, which are only put together in the repoSummary once all of them are done
+type repoRun struct {
+	ctx context.Context
+	//name is the repo as Xray indexes it, repo the name it is stored under, with -cache for remote repos
+	name, repo  string
+	pkgType     string
+	repoType    string
+	types       helpers.SupportedTypes
+	extensions  []helpers.Extensions
+	artifactory auth.ArtifactoryClient
+	xray        auth.XrayClient
+	flags       helpers.Flags
+	window      helpers.TimeWindow
+	checkpoint  *helpers.Checkpoint
+	plan        *helpers.DryRunPlan
+
+	//only used by the goroutine calling run
+	batch   reindexBatch
+	seq     int
+	batches chan<- reindexBatch
+	jobs    chan<- queueDetails
+	resume  resumePoint
+	matched matchCounts
+}
+
+//resumePoint skips the files up to the last one submitted in an earlier run, apart from the ones that failed. They
+//are only counted until it is listed, if it was deleted since the repo is listed again and resubmitted in full
+type resumePoint struct {
+	lastSubmitted string
+	failed        map[string]bool
+	//listed are the failed files found by the listing, the others were deleted since
+	listed   map[string]bool
+	resuming bool
+	skipped  int
+	resumed  int
+}
+
+//matchCounts are what the listed files were matched to
+type matchCounts struct {
+	listed, excluded, outsideWindow, notIndexable, noExt, planned int
+	unindexable                                                   map[string]int
+}
+
+//reindexTally is what the reindex workers reported
+type reindexTally struct {
+	total, failed, notSubmitted int
+	failures                    map[auth.ErrorCategory]int
+	failedArtifacts             []auth.ReindexOutcome
+}
+
+//reportTally is what the report workers reported for -indexed
+type reportTally struct {
+	total, notIndexed, skipped int
+	failures                   map[auth.ErrorCategory]int
+}
+
+//listResult is how a listing ended
+type listResult struct {
+	searched bool
+	err      error
+}
+
+//repoSummary is the outcome of a repo run
+type repoSummary struct {
+	matched   matchCounts
+	reindexed reindexTally
+	reported  reportTally
+	resumed   int
+	listing   listResult
+	stopped   bool
+}
+
+//total is the number of files submitted, planned or reported on
+func (s repoSummary) total() int {
+	return s.reindexed.total + s.matched.planned + s.reported.total
+}
+
+//notIndexed is the number of files that failed to submit or are not indexed
+func (s repoSummary) notIndexed() int {
+	return s.reindexed.failed + s.reported.notIndexed
+}
+
+//failures are the failed reindex submissions and report lookups by category
+func (s repoSummary) failures() map[auth.ErrorCategory]int {
+	failures := make(map[auth.ErrorCategory]int)
+	for category, count := range s.reindexed.failures {
+		failures[category] += count
+	}
+	for category, count := range s.reported.failures {
+		failures[category] += count
+	}
+	return failures
+}
+
+func indexRepo(ctx context.Context, repo string, pkgType string, types helpers.SupportedTypes, artifactory auth.ArtifactoryClient, xray auth.XrayClient, repoType string, flags helpers.Flags, window helpers.TimeWindow, checkpoint *helpers.Checkpoint, plan *helpers.DryRunPlan) {
+	if checkpoint.RepoCompleted(repo) {
+		log.Info("Skipping ", repo, ", it was completed in a previous run")
+		return
+	}
+	run := newRepoRun(ctx, repo, pkgType, types, artifactory, xray, repoType, flags, window, checkpoint, plan)
+	run.log(run.run())
+}
+
+func newRepoRun(ctx context.Context, repo string, pkgType string, types helpers.SupportedTypes, artifactory auth.ArtifactoryClient, xray auth.XrayClient, repoType string, flags helpers.Flags, window helpers.TimeWindow, checkpoint *helpers.Checkpoint, plan *helpers.DryRunPlan) *repoRun {
+	r := &repoRun{
+		ctx:         ctx,
+		name:        repo,
+		repo:        repo,
+		pkgType:     strings.ToLower(pkgType),
+		repoType:    repoType,
+		types:       types,
+		artifactory: artifactory,
+		xray:        xray,
+		flags:       flags,
+		window:      window,
+		checkpoint:  checkpoint,
+		plan:        plan,
+		matched:     matchCounts{unindexable: make(map[string]int)},
+	}
+	log.Debug("type:", repoType, " pkgType:", r.pkgType, " repo:", repo)
+	for i := range types.SupportedPackageTypes {
+		if types.SupportedPackageTypes[i].Type == r.pkgType {
+			log.Debug("found package type:", types.SupportedPackageTypes[i].Type)
+			r.extensions = types.SupportedPackageTypes[i].Extension
+		}
+	}
+	for y := range r.extensions {
+		log.Debug("Extension added to list:", r.extensions[y].Extension)
+	}
+	if repoType == "remote" {
+		r.repo = repo + "-cache"
+	}
+	r.resume.lastSubmitted, r.resume.failed = checkpoint.ResumePoint(repo)
+	r.resume.resuming = r.resume.lastSubmitted != ""
+	r.resume.listed = make(map[string]bool)
+	return r
+}
+
+//run lists and handles every file of the repo, waiting for the workers to finish
+func (r *repoRun) run() repoSummary {
+	batches := make(chan reindexBatch)
+	r.batches = batches
+	tallied := make(chan reindexTally, 1)
+	go func() {
+		tallied <- r.reindex(batches)
+	}()
+	jobs := make(chan queueDetails, fileQueueSize)
+	r.jobs = jobs
+	reported := make(chan reportTally, 1)
+	go func() {
+		reported <- r.report(jobs)
+	}()
+
+	listing, stopped := r.consume(false)
+	if !stopped && r.resume.resuming && listing.err == nil {
+		log.Warn("Last submitted file ", r.resume.lastSubmitted, " is no longer in ", r.repo, ", listing it again to resubmit it all")
+		r.resume.resuming = false
+		r.matched.listed = 0
+		listing, stopped = r.consume(true)
+	}
+	r.submit()
+	close(batches)
+	close(jobs)
+	return repoSummary{
+		matched:   r.matched,
+		reindexed: <-tallied,
+		reported:  <-reported,
+		resumed:   r.resume.resumed,
+		listing:   listing,
+		stopped:   stopped,
+	}
+}
+
+//consume matches the files of a new listing as they arrive, until it ends or the run is interrupted. relisted is set
+//for the second listing of a resumed repo, whose failed files were handled by the first one
+func (r *repoRun) consume(relisted bool) (listResult, bool) {
+	//the queue bounds how far the listing gets ahead
+	ctx, stopListing := context.WithCancel(r.ctx)
+	defer stopListing()
+	files := make(chan helpers.Files, fileQueueSize)
+	listed := make(chan listResult, 1)
+	go func() {
+		searched, err := listFiles(ctx, r.artifactory, r.repo, r.extensions, r.flags, r.window, files)
+		close(files)
+		listed <- listResult{searched: searched, err: err}
+	}()
+
+	var stopped bool
+	for file := range files {
+		if interrupted(r.ctx) {
+			log.Warn("Interrupted, the remaining files in ", r.repo, " were not processed")
+			if len(r.batch.Artifacts) > 0 {
+				log.Warn(len(r.batch.Artifacts), " files queued for indexing in ", r.repo, " were not submitted")
+				r.batch = reindexBatch{}
+			}
+			stopped = true
+			break
+		}
+		r.matched.listed++
+		//uris are relative to the listed folder
+		file.Uri = r.flags.FolderVar + file.Uri
+		if r.resume.failed[file.Uri] {
+			r.resume.listed[file.Uri] = true
+		}
+		if r.resume.resuming {
+			if r.resume.failed[file.Uri] {
+				r.handle(file)
+			} else {
+				r.resume.skipped++
+			}
+			if file.Uri == r.resume.lastSubmitted {
+				r.resume.resuming = false
+				r.resume.resumed += r.resume.skipped
+			}
+			continue
+		}
+		if relisted && r.resume.failed[file.Uri] {
+			continue
+		}
+		r.handle(file)
+	}
+	if stopped {
+		stopListing()
+		for range files {
+		}
+	}
+	return <-listed, stopped
+}
+
+//handle filters a listed file and queues it for reindexing, reporting or the dry run plan
+func (r *repoRun) handle(file helpers.Files) {
+	if !helpers.Selected(file.Uri, r.flags.IncludeVar, r.flags.ExcludeVar) {
+		log.Debug("Excluded:", file.Uri)
+		r.matched.excluded++
+		return
+	}
+	if !r.window.Contains(file) {
+		log.Debug("Outside the time window:", file.Uri, " created:", file.Created, " modified:", file.LastModified)
+		r.matched.outsideWindow++
+		return
+	}
+	for j := range r.extensions {
+		log.Debug("File found:", file.Uri, " matching against:", r.extensions[j].Extension)
+		if strings.Contains(file.Uri, r.extensions[j].Extension) {
+
+			if r.flags.IndexedVar != "" {
+				var queueDetails queueDetails
+				queueDetails.Repo = r.repo
+				queueDetails.PkgType = r.pkgType
+				queueDetails.Types = r.types
+				queueDetails.Artifactory = r.artifactory
+				queueDetails.Xray = r.xray
+				queueDetails.RepoType = r.repoType
+				queueDetails.Flags = r.flags
+				queueDetails.FileListData = file
+				r.jobs <- queueDetails
+			} else if r.plan != nil {
+				//dry run, count it as submitted without calling Xray
+				if err := r.plan.Add(r.repo, file.Uri); err != nil {
+					log.Fatal("Could not write dry run file: ", err)
+				}
+				r.matched.planned++
+			} else {
+				log.Info("File being sent to indexing:", file.Uri)
+				//send to indexing once the batch is full
+				r.batch.Artifacts = append(r.batch.Artifacts, auth.Artifact{Repository: r.repo, Path: file.Uri})
+				if len(r.batch.Artifacts) >= r.flags.BatchSizeVar {
+					r.submit()
+				}
+			}
+			break
+		} else if j+1 == len(r.extensions) {
+			//failed the last match
+			if r.flags.LogUnindexableVar {
+				log.Info("not indexable:", file.Uri)
+			}
+			filePath := strings.Split(file.Uri, "/")
+			fileName := filePath[len(filePath)-1]
+			fileExt := strings.Split(fileName, ".")
+			r.matched.notIndexable++
+			log.Debug("name, name array, uri:", fileName, fileExt, " ", file.Uri)
+			if len(fileExt)-1 > 0 {
+				//dont add files without file ext
+				r.matched.unindexable["."+fileExt[len(fileExt)-1]]++
+			} else {
+				r.matched.noExt++
+			}
+
+		}
+	}
+}
+
+//submit hands the current batch to the reindex workers
+func (r *repoRun) submit() {
+	if len(r.batch.Artifacts) > 0 {
+		r.batch.Seq = r.seq
+		r.seq++
+		r.batches <- r.batch
+	}
+	r.batch = reindexBatch{}
+}
+
+//reindex submits batches with the reindex worker pool until batches is closed, tallying the outcomes
+func (r *repoRun) reindex(batches <-chan reindexBatch) reindexTally {
+	submitted := make(chan reindexResult)
+	var workers sync.WaitGroup
+	for w := 1; w <= r.flags.ReindexWorkersVar; w++ {
+		workers.Add(1)
+		go func(id int) {
+			defer workers.Done()
+			reindexWorker(r.ctx, id, r.xray, batches, submitted)
+		}(w)
+	}
+	go func() {
+		workers.Wait()
+		close(submitted)
+	}()
+
+	tally := reindexTally{failures: make(map[auth.ErrorCategory]int)}
+	//batches finish out of order, progress is only saved up to the first batch not done yet
+	done := make(map[int]string)
+	nextSeq := 0
+	for result := range submitted {
+		if result.Outcomes == nil {
+			tally.notSubmitted += len(result.Batch.Artifacts)
+			continue
+		}
+		for _, outcome := range result.Outcomes {
+			if outcome.Err != nil {
+				tally.failed++
+				tally.failures[auth.Category(outcome.Err)]++
+				tally.failedArtifacts = append(tally.failedArtifacts, outcome)
+			}
+			r.checkpoint.Outcome(r.name, outcome.Artifact.Path, outcome.Err)
+			tally.total++
+		}
+		done[result.Batch.Seq] = result.Batch.Artifacts[len(result.Batch.Artifacts)-1].Path
+		for lastPath, ok := done[nextSeq]; ok; lastPath, ok = done[nextSeq] {
+			delete(done, nextSeq)
+			nextSeq++
+			if err := r.checkpoint.Submitted(r.name, lastPath); err != nil {
+				log.Warn("Could not save progress: ", err)
+			}
+		}
+	}
+	//workers finish in any order, keep the summary stable
+	sort.Slice(tally.failedArtifacts, func(i, j int) bool {
+		return tally.failedArtifacts[i].Artifact.Path < tally.failedArtifacts[j].Artifact.Path
+	})
+	return tally
+}
+
+//report looks up the statuses of jobs with the report worker pool for -indexed until jobs is closed
+func (r *repoRun) report(jobs <-chan queueDetails) reportTally {
+	reports := make(chan reportResult)
+	var reporters sync.WaitGroup
+	for w := 1; w <= r.flags.ReportWorkersVar; w++ {
+		reporters.Add(1)
+		go func(id int) {
+			defer reporters.Done()
+			worker(r.ctx, id, jobs, reports)
+		}(w)
+	}
+	go func() {
+		reporters.Wait()
+		close(reports)
+	}()
+
+	tally := reportTally{failures: make(map[auth.ErrorCategory]int)}
+	for result := range reports {
+		if result.Skipped {
+			tally.skipped++
+			continue
+		}
+		if result.Err != nil {
+			tally.failures[auth.Category(result.Err)]++
+		}
+		if !result.Indexed {
+			tally.notIndexed++
+		}
+		tally.total++
+	}
+	return tally
+}
+
+//log records the outcome of the run in the checkpoint and prints the repo summary
+func (r *repoRun) log(s repoSummary) {
+	log.Debug("File list received:", s.matched.listed, " files")
+	switch {
+	case s.listing.err == nil || s.stopped:
+	case r.ctx.Err() != nil:
+		log.Warn("Interrupted while listing ", r.repo)
+	case s.matched.listed == 0 && auth.Category(s.listing.err) != auth.ErrDecode:
+		log.Fatal("File list failed with ", s.listing.err)
+	default:
+		log.Error("File list of ", r.repo, " could not be read after ", s.matched.listed, " files, ", auth.Category(s.listing.err), " error: ", s.listing.err)
+	}
+	if s.reindexed.notSubmitted > 0 {
+		log.Warn("Interrupted, ", s.reindexed.notSubmitted, " files queued for indexing in ", r.repo, " were not submitted")
+	}
+	if s.resumed > 0 {
+		log.Info("Resumed ", r.repo, ", skipped ", s.resumed, " files handled in a previous run")
+	}
+	if !interrupted(r.ctx) && s.reindexed.notSubmitted == 0 && s.listing.err == nil {
+		//the whole repo was listed, files that failed in an earlier run and were not listed again are gone
+		var deleted []string
+		for path := range r.resume.failed {
+			if !r.resume.listed[path] {
+				deleted = append(deleted, path)
+			}
+		}
+		if len(deleted) > 0 {
+			log.Info("Dropped ", len(deleted), " failed files of ", r.repo, " that are no longer listed")
+			r.checkpoint.DropFailed(r.name, deleted)
+		}
+		if err := r.checkpoint.CompleteRepo(r.name); err != nil {
+			log.Warn("Could not save progress: ", err)
+		}
+	}
+	if s.reported.skipped > 0 {
+		log.Warn("Interrupted, ", s.reported.skipped, " files in ", r.repo, " were not analysed")
+	}
+
+	log.Info("Total indexed count:", s.total()-s.notIndexed(), "/", s.total(), " Total not indexable:", s.matched.notIndexable, " Files with no extension:", s.matched.noExt)
+	log.Info("Unindexable file types count:", s.matched.unindexable)
+	if r.flags.IncludeVar.Len() > 0 || r.flags.ExcludeVar.Len() > 0 {
+		log.Info("Files excluded by -include and -exclude:", s.matched.excluded)
+	}
+	if s.listing.searched && !r.window.Empty() {
+		log.Info("Files outside the time window were filtered out by the AQL search and are not counted")
+	} else if !r.window.Empty() {
+		log.Info("Files outside the time window:", s.matched.outsideWindow)
+	}
+	if s.listing.searched {
+		log.Info("Files without a supported extension were filtered out by the AQL search and are not counted")
+	}
+	if failures := s.failures(); len(failures) > 0 {
+		log.Warn("Failed requests by category:", failures)
+	}
+	for _, failed := range s.reindexed.failedArtifacts {
+		log.Warn("Not submitted: ", failed.Artifact.Repository+failed.Artifact.Path, " ", auth.Category(failed.Err), " error: ", failed.Err)
+	}
+}
+
+//listFiles sends the files of repo found with the -discovery backend to files. An AQL search that fails before finding
+//anything is retried with the storage list, which older or locked down instances still serve. searched reports
+//whether AQL found the files
+func listFiles(ctx context.Context, artifactory auth.ArtifactoryClient, repo string, extensions []helpers.Extensions, flags helpers.Flags, window helpers.TimeWindow, files chan<- helpers.Files) (searched bool, err error) {
+	var found int
+	send := func(file helpers.Files) error {
+		select {
+		case files <- file:
+			found++
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if flags.DiscoveryVar == "aql" {
+		var names []string
+		for i := range extensions {
+			names = append(names, extensions[i].Extension)
+		}
+		err = artifactory.SearchFiles(ctx, repo, flags.FolderVar, names, window, flags.AQLPageSizeVar, send)
+		if err == nil || found > 0 || ctx.Err() != nil {
+			return true, err
+		}
+		log.Warn("AQL search of ", repo, " failed with ", err, ", falling back to the storage list")
+	}
+	return false, artifactory.ListFiles(ctx, repo, flags.FolderVar, send)
+}
+
+//reindexBatch is a forceReindex submission, Seq orders the batches of a repo for the checkpoint
+type reindexBatch struct {
+	Seq       int
+	Artifacts []auth.Artifact
+}
+
+//reindexResult is what a reindex worker reports for a batch, Outcomes is nil when it was not submitted
+type reindexResult struct {
+	Batch    reindexBatch
+	Outcomes []auth.ReindexOutcome
+}
+
+func reindexWorker(ctx context.Context, id int, xray auth.XrayClient, batches <-chan reindexBatch, results chan<- reindexResult) {
+	for batch := range batches {
+		if interrupted(ctx) {
+			results <- reindexResult{Batch: batch}
+			continue
+		}
+		log.Debug("reindex worker ", id, " submitting ", len(batch.Artifacts), " artifacts")
+		results <- reindexResult{Batch: batch, Outcomes: auth.ReindexBatch(ctx, xray, batch.Artifacts)}
+	}
+}
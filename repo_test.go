@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/lorenyeung/forceReindexXray/auth"
+	"github.com/lorenyeung/forceReindexXray/helpers"
+)
+
+//fakeArtifactory lists uris as the files of every repo
+type fakeArtifactory struct {
+	auth.ArtifactoryClient
+	uris []string
+}
+
+func (a fakeArtifactory) ListFiles(ctx context.Context, repo, folder string, found func(helpers.Files) error) error {
+	for _, uri := range a.uris {
+		if err := found(helpers.Files{Uri: uri}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a fakeArtifactory) FileInfo(ctx context.Context, repo, path string) (helpers.FileInfo, error) {
+	return helpers.FileInfo{Size: "1024", MimeType: "application/x-gzip"}, nil
+}
+
+//fakeXray reports every artifact on its own, rejecting the ones in rejected. statuses are the scan statuses by path,
+//paths without one fail the lookup with a server error
+type fakeXray struct {
+	auth.XrayClient
+	rejected map[string]bool
+	statuses map[string]string
+
+	mu        sync.Mutex
+	submitted []string
+}
+
+func (x *fakeXray) ForceReindex(ctx context.Context, artifacts []auth.Artifact) []auth.ReindexOutcome {
+	results := make([]auth.ReindexOutcome, len(artifacts))
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for i, artifact := range artifacts {
+		x.submitted = append(x.submitted, artifact.Path)
+		results[i].Artifact = artifact
+		if x.rejected[artifact.Path] {
+			results[i].Err = &auth.RequestError{Category: auth.ErrRequest, Method: "POST", StatusCode: 400}
+		}
+	}
+	return results
+}
+
+func (x *fakeXray) ArtifactStatus(ctx context.Context, repo, path string) (auth.ArtifactStatus, error) {
+	status, ok := x.statuses[path]
+	if !ok {
+		return auth.ArtifactStatus{}, &auth.RequestError{Category: auth.ErrServer, Method: "POST", StatusCode: 500}
+	}
+	return auth.ArtifactStatus{Status: status, Indexed: status == "DONE"}, nil
+}
+
+func (x *fakeXray) sortedSubmitted() []string {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	submitted := append([]string(nil), x.submitted...)
+	sort.Strings(submitted)
+	return submitted
+}
+
+var npmTypes = helpers.SupportedTypes{SupportedPackageTypes: []helpers.SupportedPackageType{
+	{Type: "npm", Extension: []helpers.Extensions{{Extension: ".tgz"}}},
+}}
+
+var npmFiles = []string{"/a.tgz", "/b.tgz", "/c.tgz", "/README.md", "/LICENSE", "/x/d.tgz"}
+
+func testFlags(t *testing.T) helpers.Flags {
+	t.Helper()
+	var exclude helpers.Patterns
+	if err := exclude.Set("x/**"); err != nil {
+		t.Fatal(err)
+	}
+	return helpers.Flags{BatchSizeVar: 2, ReindexWorkersVar: 2, ReportWorkersVar: 2, DiscoveryVar: "storage", ExcludeVar: exclude}
+}
+
+func TestRepoRunReindex(t *testing.T) {
+	xray := &fakeXray{rejected: map[string]bool{"/c.tgz": true}}
+	run := newRepoRun(context.Background(), "npm-local", "NPM", npmTypes, fakeArtifactory{uris: npmFiles}, xray, "local", testFlags(t), helpers.TimeWindow{}, nil, nil)
+	summary := run.run()
+
+	if got := xray.sortedSubmitted(); !reflect.DeepEqual(got, []string{"/a.tgz", "/b.tgz", "/c.tgz"}) {
+		t.Errorf("submitted %v", got)
+	}
+	want := matchCounts{listed: 6, excluded: 1, notIndexable: 2, noExt: 1, unindexable: map[string]int{".md": 1}}
+	if !reflect.DeepEqual(summary.matched, want) {
+		t.Errorf("matched %+v, want %+v", summary.matched, want)
+	}
+	if summary.total() != 3 || summary.notIndexed() != 1 {
+		t.Errorf("indexed %d/%d, want 2/3", summary.total()-summary.notIndexed(), summary.total())
+	}
+	if !reflect.DeepEqual(summary.failures(), map[auth.ErrorCategory]int{auth.ErrRequest: 1}) {
+		t.Errorf("failures %v", summary.failures())
+	}
+	if len(summary.reindexed.failedArtifacts) != 1 || summary.reindexed.failedArtifacts[0].Artifact.Path != "/c.tgz" {
+		t.Errorf("failed artifacts %v, want /c.tgz", summary.reindexed.failedArtifacts)
+	}
+}
+
+func TestRepoRunReport(t *testing.T) {
+	tests := []struct {
+		mode                  string
+		wantTotal, notIndexed int
+	}{
+		{"unindexed", 3, 2},
+		{"all", 3, 2},
+	}
+	for _, test := range tests {
+		t.Run(test.mode, func(t *testing.T) {
+			//c.tgz has no status, its lookup fails
+			xray := &fakeXray{statuses: map[string]string{"/a.tgz": "DONE", "/b.tgz": "NOT_SCANNED"}}
+			flags := testFlags(t)
+			flags.IndexedVar = test.mode
+			run := newRepoRun(context.Background(), "npm-local", "npm", npmTypes, fakeArtifactory{uris: npmFiles}, xray, "local", flags, helpers.TimeWindow{}, nil, nil)
+			summary := run.run()
+
+			if len(xray.sortedSubmitted()) != 0 {
+				t.Errorf("report submitted %v", xray.sortedSubmitted())
+			}
+			if summary.total() != test.wantTotal || summary.notIndexed() != test.notIndexed {
+				t.Errorf("indexed %d/%d, want %d/%d", summary.total()-summary.notIndexed(), summary.total(), test.wantTotal-test.notIndexed, test.wantTotal)
+			}
+			if !reflect.DeepEqual(summary.failures(), map[auth.ErrorCategory]int{auth.ErrServer: 1}) {
+				t.Errorf("failures %v, want the failed status lookup", summary.failures())
+			}
+		})
+	}
+}
+
+func TestRepoRunDryRun(t *testing.T) {
+	plan, err := helpers.OpenDryRunPlan("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	xray := &fakeXray{}
+	run := newRepoRun(context.Background(), "npm-local", "npm", npmTypes, fakeArtifactory{uris: npmFiles}, xray, "local", testFlags(t), helpers.TimeWindow{}, nil, plan)
+	summary := run.run()
+
+	if len(xray.sortedSubmitted()) != 0 {
+		t.Errorf("dry run submitted %v", xray.sortedSubmitted())
+	}
+	if summary.matched.planned != 3 || plan.Count != 3 {
+		t.Errorf("planned %d, plan has %d, want 3", summary.matched.planned, plan.Count)
+	}
+}
+
+func TestRepoRunResume(t *testing.T) {
+	tests := []struct {
+		name          string
+		lastSubmitted string
+		failed        []string
+		wantSubmitted []string
+		wantResumed   int
+	}{
+		//a failed earlier and is retried, b and c were submitted
+		{"resume", "/c.tgz", []string{"/a.tgz"}, []string{"/a.tgz"}, 2},
+		{"resume midway", "/b.tgz", nil, []string{"/c.tgz"}, 2},
+		//the last submitted file was deleted, everything but the failed files already retried is submitted again
+		{"relist", "/deleted.tgz", []string{"/a.tgz"}, []string{"/a.tgz", "/b.tgz", "/c.tgz"}, 0},
+		//a failed file that was deleted since no longer keeps the repo from completing
+		{"failed file deleted", "/c.tgz", []string{"/gone.tgz"}, nil, 3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "repo")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			path := filepath.Join(dir, "state.json")
+			saved, err := helpers.OpenCheckpoint(path, "http://arti", "repo=npm-local", helpers.TimeWindow{}, false, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, failed := range test.failed {
+				saved.Outcome("npm-local", failed, errors.New("rejected"))
+			}
+			if err := saved.Submitted("npm-local", test.lastSubmitted); err != nil {
+				t.Fatal(err)
+			}
+			checkpoint, err := helpers.OpenCheckpoint(path, "http://arti", "repo=npm-local", helpers.TimeWindow{}, true, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			xray := &fakeXray{}
+			run := newRepoRun(context.Background(), "npm-local", "npm", npmTypes, fakeArtifactory{uris: npmFiles}, xray, "local", testFlags(t), helpers.TimeWindow{}, checkpoint, nil)
+			summary := run.run()
+			run.log(summary)
+
+			if got := xray.sortedSubmitted(); !reflect.DeepEqual(got, test.wantSubmitted) {
+				t.Errorf("submitted %v, want %v", got, test.wantSubmitted)
+			}
+			if summary.resumed != test.wantResumed {
+				t.Errorf("resumed past %d files, want %d", summary.resumed, test.wantResumed)
+			}
+			//every file was submitted without failures
+			if !checkpoint.RepoCompleted("npm-local") || checkpoint.FailedCount() != 0 {
+				t.Errorf("completed %t with %d failed files, want completed", checkpoint.RepoCompleted("npm-local"), checkpoint.FailedCount())
+			}
+		})
+	}
+}
+
+func TestIndexRepoSkipsCompleted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	checkpoint, err := helpers.OpenCheckpoint(filepath.Join(dir, "state.json"), "http://arti", "all=true", helpers.TimeWindow{}, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkpoint.CompleteRepo("npm-local"); err != nil {
+		t.Fatal(err)
+	}
+	xray := &fakeXray{}
+	indexRepo(context.Background(), "npm-local", "npm", npmTypes, fakeArtifactory{uris: npmFiles}, xray, "local", testFlags(t), helpers.TimeWindow{}, checkpoint, nil)
+	if got := xray.sortedSubmitted(); len(got) != 0 {
+		t.Errorf("completed repo was submitted again: %v", got)
+	}
+}